@@ -0,0 +1,355 @@
+package main
+
+/*
+Agenda files follow the sample at the top of agenda_node.go. Each heading is
+indented two spaces per level and starts with "* ". Its TODO keyword, priority
+and tags are written like in Heading(). Its text, and that of its continuations, is
+indented two spaces more than the heading:
+
+* TODO [#A] Heading 1 :work:
+  :PROPERTIES:
+  :EFFORT: 1:00
+  :END:
+  CLOCK: [2020-09-14 09:00:00]--[2020-09-14 10:30:00]
+  text 1-1
+  * Sub-Heading 1a
+    text 1a-1
+  text 1-2
+
+A title that would be read as a TODO keyword, priority or tags is escaped with a "\" at its
+start or end.
+
+Text following a child heading starts a new continuation. So does a line
+holding just "+", which is written wherever that rule isn't enough, eg. for a
+continuation without text. Properties and clocks may only come right after the
+heading. Text lines that would be mistaken for any of this are escaped with a
+leading "\". Text before the first heading belongs to the root.
+
+Blank lines at the start or end of a text are not preserved.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const clockTimeFormat = "2006-01-02 15:04:05"
+
+var (
+	headingTags = regexp.MustCompile(`^(.*?)\s*:((?:[^\s:]+:)+)$`)
+	clockLine   = regexp.MustCompile(`^CLOCK: \[([^\]]+)\](?:--\[([^\]]+)\])?$`)
+	propertyRe  = regexp.MustCompile(`^:([^\s:]+):(?: (.*))?$`)
+	tagName     = regexp.MustCompile(`^[^\s:]+$`)
+)
+
+// Tags may not contain whitespace or ":", which separates them in headings.
+func ValidTag(tag string) bool {
+	return tagName.MatchString(tag)
+}
+
+func ParseAgenda(r io.Reader) (*AgendaNode, error) {
+	type openHeading struct {
+		node    *AgendaNode
+		segment *AgendaNode
+		// Properties and clocks are allowed until the first text line.
+		inHeader bool
+		inDrawer bool
+	}
+
+	root := NewNode("", "")
+	var stack []*openHeading
+	texts := map[*AgendaNode][]string{}
+	var lastText *AgendaNode
+	blanks := 0
+
+	addText := func(segment *AgendaNode, text string) {
+		if segment == lastText {
+			for ; blanks > 0; blanks-- {
+				texts[segment] = append(texts[segment], "")
+			}
+		}
+		blanks = 0
+		texts[segment] = append(texts[segment], text)
+		lastText = segment
+	}
+
+	continueHeading := func(open *openHeading) {
+		segment := NewNode("", "")
+		open.node.AddContinuation(segment)
+		open.segment = segment
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		content := strings.TrimLeft(text, " ")
+		indent := len(text) - len(content)
+
+		if content == "" {
+			blanks++
+			continue
+		}
+
+		if strings.HasPrefix(content, "* ") || content == "*" {
+			if indent%2 != 0 {
+				return nil, fmt.Errorf("line %d: heading indented by an odd number of spaces", line)
+			}
+			depth := indent / 2
+			if depth > len(stack) {
+				return nil, fmt.Errorf("line %d: heading nested more than one level below its parent", line)
+			}
+			stack = stack[:depth]
+
+			parent := root
+			if depth > 0 {
+				parent = stack[depth-1].segment
+			}
+			node := parseHeading(strings.TrimPrefix(strings.TrimPrefix(content, "*"), " "))
+			parent.AddChild(node)
+			stack = append(stack, &openHeading{node: node, segment: node, inHeader: true})
+			blanks = 0
+			continue
+		}
+
+		if len(stack) == 0 {
+			addText(root, strings.TrimPrefix(text, "\\"))
+			continue
+		}
+		if indent < 2 {
+			return nil, fmt.Errorf("line %d: text outside of any heading", line)
+		}
+
+		depth := indent/2 - 1
+		if depth >= len(stack) {
+			depth = len(stack) - 1
+		}
+		stack = stack[:depth+1]
+		open := stack[depth]
+		content = text[(depth+1)*2:]
+
+		if open.inDrawer {
+			if content == ":END:" {
+				open.inDrawer = false
+				continue
+			}
+			match := propertyRe.FindStringSubmatch(content)
+			if match == nil {
+				return nil, fmt.Errorf("line %d: expected \":KEY: value\" or \":END:\"", line)
+			}
+			open.node.Properties.Set(match[1], match[2])
+			continue
+		}
+		if open.inHeader {
+			if content == ":PROPERTIES:" {
+				open.inDrawer = true
+				continue
+			}
+			if match := clockLine.FindStringSubmatch(content); match != nil {
+				interval, err := parseClockInterval(match[1], match[2])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", line, err)
+				}
+				open.node.Clocks = append(open.node.Clocks, interval)
+				continue
+			}
+			open.inHeader = false
+		}
+
+		if content == "+" {
+			continueHeading(open)
+			continue
+		}
+		if len(open.segment.Children) > 0 {
+			continueHeading(open)
+		}
+		addText(open.segment, strings.TrimPrefix(content, "\\"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, open := range stack {
+		if open.inDrawer {
+			return nil, fmt.Errorf("%v: properties without :END:", open.node.Title)
+		}
+	}
+
+	for segment, lines := range texts {
+		segment.Text = strings.Join(lines, "\n")
+	}
+	return root, nil
+}
+
+// Parses what follows "* " on a heading line, as written by formatHeading.
+func parseHeading(text string) *AgendaNode {
+	node := NewNode("", "")
+
+	node.Todo, text = parseTodo(text)
+	node.Priority, text = parsePriority(text)
+	text = strings.TrimPrefix(text, "\\")
+
+	if strings.HasSuffix(text, "\\") {
+		text = text[:len(text)-1]
+	} else if match := headingTags.FindStringSubmatch(text); match != nil {
+		text = match[1]
+		node.Tags = strings.Split(strings.TrimSuffix(match[2], ":"), ":")
+	}
+
+	node.Title = text
+	return node
+}
+
+// Splits a leading priority cookie, eg. "[#A] ", off text.
+func parsePriority(text string) (rune, string) {
+	if len(text) >= 4 && strings.HasPrefix(text, "[#") && text[3] == ']' {
+		for _, priority := range Priorities {
+			if rune(text[2]) == priority {
+				return priority, strings.TrimPrefix(text[4:], " ")
+			}
+		}
+	}
+	return 0, text
+}
+
+// What follows "* " on the heading line of node: its TODO keyword, priority,
+// title and tags. A title that would be read as any of the others is escaped
+// with "\".
+func formatHeading(node *AgendaNode) string {
+	title := node.Title
+	priority, _ := parsePriority(title)
+	todo, _ := parseTodo(title)
+	if strings.HasPrefix(title, "\\") || (node.Priority == 0 && (priority != 0 || (node.Todo == "" && todo != ""))) {
+		title = "\\" + title
+	}
+	if len(node.Tags) == 0 && (headingTags.MatchString(title) || strings.HasSuffix(title, "\\")) {
+		title += "\\"
+	}
+
+	heading := title
+	if node.Priority != 0 {
+		heading = fmt.Sprintf("[#%c] %v", node.Priority, heading)
+	}
+	if node.Todo != "" {
+		heading = strings.TrimRight(node.Todo+" "+heading, " ")
+	}
+	if len(node.Tags) > 0 {
+		heading = fmt.Sprintf("%v :%v:", heading, strings.Join(node.Tags, ":"))
+	}
+	return heading
+}
+
+func parseClockInterval(start, end string) (interval ClockInterval, err error) {
+	if interval.Start, err = time.ParseInLocation(clockTimeFormat, start, time.Local); err != nil {
+		return
+	}
+	if end != "" {
+		interval.End, err = time.ParseInLocation(clockTimeFormat, end, time.Local)
+	}
+	return
+}
+
+func WriteAgenda(w io.Writer, root *AgendaNode) error {
+	out := bufio.NewWriter(w)
+	writeText(out, "", root.Text)
+	for _, child := range root.Children {
+		writeHeading(out, child, 0)
+	}
+	return out.Flush()
+}
+
+func writeHeading(out *bufio.Writer, node *AgendaNode, depth int) {
+	indent := strings.Repeat("  ", depth)
+	body := indent + "  "
+
+	fmt.Fprintf(out, "%v* %v\n", indent, formatHeading(node))
+
+	if node.Properties.Len() > 0 {
+		fmt.Fprintf(out, "%v:PROPERTIES:\n", body)
+		for _, key := range node.Properties.Keys {
+			fmt.Fprintf(out, "%v:%v: %v\n", body, key, node.Properties.Values[key])
+		}
+		fmt.Fprintf(out, "%v:END:\n", body)
+	}
+	for _, interval := range node.Clocks {
+		fmt.Fprintf(out, "%vCLOCK: [%v]", body, interval.Start.Format(clockTimeFormat))
+		if !interval.IsRunning() {
+			fmt.Fprintf(out, "--[%v]", interval.End.Format(clockTimeFormat))
+		}
+		fmt.Fprintln(out)
+	}
+
+	for segment := node; segment != nil; segment = segment.NextContinuation {
+		if segment != node && (segment.Text == "" || len(segment.PrevContinuation.Children) == 0) {
+			fmt.Fprintf(out, "%v+\n", body)
+		}
+		writeText(out, body, segment.Text)
+		for _, child := range segment.Children {
+			writeHeading(out, child, depth+1)
+		}
+	}
+}
+
+func writeText(out *bufio.Writer, indent, text string) {
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			fmt.Fprintln(out)
+			continue
+		}
+		trimmed := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(trimmed, "*") || strings.HasPrefix(trimmed, "+") || strings.HasPrefix(trimmed, ":") ||
+			strings.HasPrefix(trimmed, "CLOCK:") || strings.HasPrefix(trimmed, "\\") || line != trimmed {
+			line = "\\" + line
+		}
+		fmt.Fprintf(out, "%v%v\n", indent, line)
+	}
+}
+
+// The agenda as it would be written to a file.
+func FormatAgenda(root *AgendaNode) []byte {
+	var buffer bytes.Buffer
+	WriteAgenda(&buffer, root)
+	return buffer.Bytes()
+}
+
+func LoadAgendaFile(path string) (*AgendaNode, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	root, err := ParseAgenda(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return root, nil
+}
+
+// Moves the children and text of other into root, replacing its own.
+func (root *AgendaNode) ReplaceContents(other *AgendaNode) {
+	root.Text = other.Text
+	root.Children = other.Children
+	for _, child := range root.Children {
+		child.Parent = root
+	}
+	other.Children = nil
+	root.Touch()
+}
+
+// The node with a running clock, if any.
+func (root *AgendaNode) RunningClock() (running *AgendaNode) {
+	root.Walk(func(node *AgendaNode, _ int) {
+		if n := len(node.Clocks); n > 0 && node.Clocks[n-1].IsRunning() {
+			running = node
+		}
+	})
+	return
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgendaFileRoundTrip(t *testing.T) {
+	root := NewAgendaTree()
+	root.Text = "Preamble\n* not a heading"
+	rc1 := root.Children[0]
+	rc1.Todo = "TODO"
+	rc1.Priority = 'A'
+	rc1.Tags = []string{"work", "urgent"}
+	rc1.Properties.Set("EFFORT", "1:30")
+	rc1.Clocks = []ClockInterval{
+		{Start: time.Date(2020, 9, 14, 9, 0, 0, 0, time.Local), End: time.Date(2020, 9, 14, 10, 30, 0, 0, time.Local)},
+		{Start: time.Date(2020, 9, 15, 9, 0, 0, 0, time.Local)},
+	}
+	rc1.Text = "first line\n\n  indented\n+ plus\nCLOCK: not a clock"
+	root.Children[1].AddChild(NewNode("", ""))
+	escaped := []*AgendaNode{
+		NewNode("Meet at 10:30:", ""),
+		NewNode("[#A] is not a priority", ""),
+		NewNode(`\ ends in a backslash \`, ""),
+		{Title: "[#B] after a priority", Priority: 'C'},
+		{Title: "tagged:", Tags: []string{"x"}},
+		NewNode("TODO is not a keyword", ""),
+		NewNode("DONE", ""),
+		{Title: "[#A] after a keyword", Todo: "TODO"},
+		{Title: "DONE after a priority", Priority: 'B'},
+		{Title: "", Todo: "DONE"},
+	}
+	for _, node := range escaped {
+		root.Children[1].AddChild(node)
+	}
+
+	data := FormatAgenda(root)
+	parsed, err := ParseAgenda(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again := FormatAgenda(parsed); !bytes.Equal(again, data) {
+		t.Errorf("Expected:\n%s\ngot:\n%s", data, again)
+	}
+	if a, b := outline(root), outline(parsed); strings.Join(a, "\n") != strings.Join(b, "\n") {
+		t.Errorf("Expected outline:\n%v\ngot:\n%v", strings.Join(a, "\n"), strings.Join(b, "\n"))
+	}
+	if parsed.Children[0].Text != rc1.Text || parsed.Text != root.Text {
+		t.Errorf("Expected texts %q and %q, got %q and %q", root.Text, rc1.Text, parsed.Text, parsed.Children[0].Text)
+	}
+	for i, node := range escaped {
+		actual := parsed.Children[1].Children[i+1]
+		if actual.Title != node.Title || actual.Todo != node.Todo || actual.Priority != node.Priority || strings.Join(actual.Tags, ":") != strings.Join(node.Tags, ":") {
+			t.Errorf("Expected %q with %q, priority %q and tags %v, got %q with %q, %q and %v", node.Title, node.Todo, node.Priority, node.Tags, actual.Title, actual.Todo, actual.Priority, actual.Tags)
+		}
+	}
+	if parsed.RunningClock() != parsed.Children[0] {
+		t.Errorf("Expected the running clock to be read back")
+	}
+}
+
+func TestParseAgendaSample(t *testing.T) {
+	sample := `* Heading 1
+  text 1-1
+
+  * Sub-Heading 1a
+    text 1a-1
+
+    * Sub-Sub Heading 1aa
+      text 1aa-1
+
+    text 1a-2
+
+  text 1-2
+
+  * Sub-Heading 1b
+    text 1b-1
+
+  text 1-3
+`
+	root, err := ParseAgenda(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOutline(t, root, "Heading 1", "  Sub-Heading 1a", "    Sub-Sub Heading 1aa", "  Sub-Heading 1b")
+
+	var texts []string
+	for segment := root.Children[0]; segment != nil; segment = segment.NextContinuation {
+		texts = append(texts, segment.Text)
+	}
+	if strings.Join(texts, "|") != "text 1-1|text 1-2|text 1-3" {
+		t.Errorf("Expected three segments, got %q", texts)
+	}
+	if text := root.Children[0].Children[0].NextContinuation.Text; text != "text 1a-2" {
+		t.Errorf("Expected continuation of 1a, got %q", text)
+	}
+}
@@ -58,6 +58,13 @@ type AgendaNode struct {
 	PrevContinuation *AgendaNode
 	Children         []*AgendaNode
 	Tags             []string
+	Clocks           []ClockInterval
+	Priority         rune
+	Todo             string
+	Properties       Properties
+	Folded           bool
+	// Bumped by Touch on every change to the heading or anything below it.
+	revision int
 }
 
 // func main() {
@@ -98,6 +105,37 @@ func (node *AgendaNode) Write(w io.Writer, indentLevel int, indentScale int) {
 	io.WriteString(w, fmt.Sprintf("%*s%v\n", indentLevel*indentScale, " ", node.Text))
 }
 
+// Title as rendered, including the TODO keyword and priority cookie if any.
+func (node *AgendaNode) Heading() string {
+	heading := node.Title
+	if node.Priority != 0 {
+		heading = fmt.Sprintf("[#%c] %v", node.Priority, heading)
+	}
+	if node.Todo != "" {
+		heading = fmt.Sprintf("%v %v", node.Todo, heading)
+	}
+	return heading
+}
+
+// Marks node as changed, along with every heading above it, so their Revision
+// changes. Called by the methods changing nodes. Code setting fields directly
+// must call it too.
+func (node *AgendaNode) Touch() {
+	for head := node.Head(); head != nil; {
+		head.revision++
+		if head.Parent == nil {
+			break
+		}
+		head = head.Parent.Head()
+	}
+}
+
+// A counter that changes whenever node or anything below it does. Folding isn't
+// a change.
+func (node *AgendaNode) Revision() int {
+	return node.Head().revision
+}
+
 func NewNode(title, text string, tags ...string) *AgendaNode {
 	new := &AgendaNode{Title: title, Text: text, Tags: tags}
 	return new
@@ -106,6 +144,7 @@ func NewNode(title, text string, tags ...string) *AgendaNode {
 func (parent *AgendaNode) AddChild(child *AgendaNode) {
 	parent.Children = append(parent.Children, child)
 	child.Parent = parent
+	parent.Touch()
 }
 
 func (parent *AgendaNode) InsertChild(child *AgendaNode, index int) error {
@@ -130,13 +169,14 @@ func (parent *AgendaNode) InsertChild(child *AgendaNode, index int) error {
 			str = fmt.Sprintf("%s %s", str, rest[i].Title)
 		}
 
-		log.Log("%s", str)
+		log.Debug("%s", str)
 
 		new := append([]*AgendaNode{child}, parent.Children[index:]...)
 		parent.Children = append(parent.Children[:index], new...)
 	}
 
 	child.Parent = parent
+	parent.Touch()
 
 	return nil
 }
@@ -151,6 +191,27 @@ func (parent *AgendaNode) RemoveChild(child *AgendaNode) {
 	}
 
 	child.Parent = nil
+	parent.Touch()
+}
+
+func (node *AgendaNode) AddTag(tag string) {
+	for i := range node.Tags {
+		if node.Tags[i] == tag {
+			return
+		}
+	}
+	node.Tags = append(node.Tags, tag)
+	node.Touch()
+}
+
+func (node *AgendaNode) RemoveTag(tag string) {
+	for i := range node.Tags {
+		if node.Tags[i] == tag {
+			node.Tags = append(node.Tags[:i], node.Tags[i+1:]...)
+			node.Touch()
+			return
+		}
+	}
 }
 
 func (node *AgendaNode) AddContinuation(new *AgendaNode) {
@@ -158,6 +219,7 @@ func (node *AgendaNode) AddContinuation(new *AgendaNode) {
 	}
 	node.NextContinuation = new
 	new.PrevContinuation = node
+	node.Touch()
 }
 
 func (parent *AgendaNode) IndexChild(child *AgendaNode) int {
@@ -199,7 +261,7 @@ func (root *AgendaNode) Prev(subject *AgendaNode) (wanted *AgendaNode) {
 	wanted = nil
 	var last *AgendaNode = nil
 
-	root.Walk(func(visitee *AgendaNode, _ int) {
+	root.WalkUnfolded(func(visitee *AgendaNode, _ int) {
 		if visitee == subject {
 			wanted = last
 		}
@@ -215,7 +277,7 @@ func (root *AgendaNode) Next(subject *AgendaNode) (wanted *AgendaNode) {
 	wanted = nil
 	var last *AgendaNode = nil
 
-	root.Walk(func(visitee *AgendaNode, _ int) {
+	root.WalkUnfolded(func(visitee *AgendaNode, _ int) {
 		if last == subject && !visitee.IsContinuation() {
 			wanted = visitee
 		}
@@ -253,6 +315,26 @@ func (node *AgendaNode) Walk(callback func(*AgendaNode, int)) {
 	}
 }
 
+// Like Walk, but skips the text, continuations and children of folded nodes.
+func (node *AgendaNode) WalkUnfolded(callback func(*AgendaNode, int)) {
+	folded := -1
+
+	node.Walk(func(visitee *AgendaNode, depth int) {
+		if folded != -1 {
+			if depth > folded || (depth == folded && visitee.IsContinuation()) {
+				return
+			}
+			folded = -1
+		}
+
+		callback(visitee, depth)
+
+		if visitee.Folded {
+			folded = depth
+		}
+	})
+}
+
 // Move a node "down".
 //   If there are other children:
 //     Shuffle the organization so this child gets a higher index .
@@ -264,7 +346,7 @@ func (node *AgendaNode) Walk(callback func(*AgendaNode, int)) {
 //
 func (subject *AgendaNode) MakeNextSibling() {
 	if subject.IsContinuation() {
-		log.Log("No parent, only works for non-continuation nodes.")
+		log.Info("No parent, only works for non-continuation nodes.")
 		return
 	}
 
@@ -273,7 +355,7 @@ func (subject *AgendaNode) MakeNextSibling() {
 	count := len(parent.Children)
 
 	if index == count-1 {
-		log.Log("node already at last index.")
+		log.Info("node already at last index.")
 		return
 	}
 
@@ -282,6 +364,7 @@ func (subject *AgendaNode) MakeNextSibling() {
 	parent.Children = append(parent.Children, rest[0])
 	parent.Children = append(parent.Children, subject)
 	parent.Children = append(parent.Children, rest[1:]...)
+	parent.Touch()
 }
 
 // Move a node "up".
@@ -296,7 +379,7 @@ func (subject *AgendaNode) MakeNextSibling() {
 func (subject *AgendaNode) MakePrevSibling() {
 	// This only works for non-continuation nodes. ie., must have a parent.
 	if subject.IsContinuation() {
-		log.Log("No parent, only works for non-continuation nodes.")
+		log.Info("No parent, only works for non-continuation nodes.")
 		return
 	}
 
@@ -304,12 +387,13 @@ func (subject *AgendaNode) MakePrevSibling() {
 	index := parent.IndexChild(subject)
 
 	if index == 0 {
-		log.Log("node already at first index.")
+		log.Info("node already at first index.")
 		return
 	}
 
 	a, b := parent.Children[index-1], parent.Children[index]
 	parent.Children[index-1], parent.Children[index] = b, a
+	parent.Touch()
 }
 
 // Makes subject the next sibling of its current parent.
@@ -318,7 +402,7 @@ func (subject *AgendaNode) MakePrevSibling() {
 func (subject *AgendaNode) MoveUpTree() {
 	parentSib, err := subject.ParentContinuationWithParent()
 	if err != nil {
-		log.Log("Couldn't find parent's parent")
+		log.Warn("Couldn't find parent's parent")
 		return
 	}
 
@@ -340,17 +424,17 @@ func (subject *AgendaNode) MoveUpTree() {
 //
 func (subject *AgendaNode) MoveDownTree() {
 	if subject.IsContinuation() {
-		log.Log("Node must have a parent.")
+		log.Info("Node must have a parent.")
 		return
 	}
 	parent := subject.Parent
 	index := parent.IndexChild(subject)
 	if len(parent.Children) == 1 {
-		log.Log("Must have at least one sibling.")
+		log.Info("Must have at least one sibling.")
 		return
 	}
 	if index == 0 {
-		log.Log("Can't indent from here. Try moving up first.")
+		log.Info("Can't indent from here. Try moving up first.")
 		return
 	}
 
@@ -394,4 +478,5 @@ func (src *AgendaNode) replaceWith(dst *AgendaNode) {
 			dst.Parent.Children[index] = dst
 		}
 	}
+	dst.Touch()
 }
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Hands out the bytes of a fuzz input as small numbers. Reads past the end
+// return 0, so every input decodes to something.
+type byteSource struct {
+	data []byte
+}
+
+func (src *byteSource) next(n int) int {
+	if n <= 0 || len(src.data) == 0 {
+		return 0
+	}
+	b := src.data[0]
+	src.data = src.data[1:]
+	return int(b) % n
+}
+
+func (src *byteSource) empty() bool {
+	return len(src.data) == 0
+}
+
+// Builds a tree of size nodes. Each node is either a heading added as a child
+// of an existing heading, continuation or the root, or a continuation added to
+// an existing heading.
+func generateTree(src *byteSource, size int) *AgendaNode {
+	root := NewNode("root", "")
+	parents := []*AgendaNode{root}
+	var headings []*AgendaNode
+
+	for i := 0; i < size; i++ {
+		node := NewNode(fmt.Sprintf("n%d", i), "")
+		if len(headings) > 0 && src.next(3) == 2 {
+			headings[src.next(len(headings))].AddContinuation(node)
+		} else {
+			parents[src.next(len(parents))].AddChild(node)
+			headings = append(headings, node)
+		}
+		parents = append(parents, node)
+	}
+	return root
+}
+
+type walkEntry struct {
+	node  *AgendaNode
+	depth int
+}
+
+func walkEntries(root *AgendaNode) (entries []walkEntry) {
+	root.Walk(func(node *AgendaNode, depth int) {
+		entries = append(entries, walkEntry{node, depth})
+	})
+	return
+}
+
+func formatEntries(entries []walkEntry) string {
+	var lines []string
+	for _, entry := range entries {
+		lines = append(lines, fmt.Sprintf("%*s%v", entry.depth*2, "", entry.node.Title))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func headingsOf(entries []walkEntry) (headings []*AgendaNode) {
+	for _, entry := range entries {
+		if !entry.node.IsContinuation() {
+			headings = append(headings, entry.node)
+		}
+	}
+	return
+}
+
+func indexEntry(entries []walkEntry, node *AgendaNode) int {
+	for i := range entries {
+		if entries[i].node == node {
+			return i
+		}
+	}
+	return -1
+}
+
+// The end of the block of entries starting at the heading entries[start]: its
+// children, its continuations and theirs.
+func blockEnd(entries []walkEntry, start int) int {
+	depth := entries[start].depth
+	end := start + 1
+	for ; end < len(entries); end++ {
+		entry := entries[end]
+		if entry.depth < depth || (entry.depth == depth && !entry.node.IsContinuation()) {
+			break
+		}
+	}
+	return end
+}
+
+func shiftDepth(entries []walkEntry, by int) []walkEntry {
+	shifted := make([]walkEntry, len(entries))
+	for i := range entries {
+		shifted[i] = walkEntry{entries[i].node, entries[i].depth + by}
+	}
+	return shifted
+}
+
+// Moves the block starting at from so it starts where the block starting at to
+// did, shifting its depth by depth. to must not be inside the block.
+func moveBlock(entries []walkEntry, from, to, depth int) []walkEntry {
+	end := blockEnd(entries, from)
+	block := shiftDepth(entries[from:end], depth)
+
+	var rest []walkEntry
+	rest = append(rest, entries[:from]...)
+	rest = append(rest, entries[end:]...)
+	if to > from {
+		to -= end - from
+	}
+
+	var moved []walkEntry
+	moved = append(moved, rest[:to]...)
+	moved = append(moved, block...)
+	moved = append(moved, rest[to:]...)
+	return moved
+}
+
+// Checks the pointers between parents, children and continuations agree, and
+// that every node in nodes is reachable from root exactly once.
+func checkTree(root *AgendaNode, nodes []*AgendaNode) error {
+	seen := map[*AgendaNode]bool{}
+
+	var check func(node *AgendaNode) error
+	check = func(node *AgendaNode) error {
+		if seen[node] {
+			return fmt.Errorf("%v is reachable twice", node.Title)
+		}
+		seen[node] = true
+
+		for _, child := range node.Children {
+			if child.Parent != node {
+				return fmt.Errorf("%v is a child of %v but has parent %v", child.Title, node.Title, titleOf(child.Parent))
+			}
+			if child.PrevContinuation != nil {
+				return fmt.Errorf("%v is a child of %v but continues %v", child.Title, node.Title, child.PrevContinuation.Title)
+			}
+			if err := check(child); err != nil {
+				return err
+			}
+		}
+
+		if next := node.NextContinuation; next != nil {
+			if next.Parent != nil {
+				return fmt.Errorf("%v continues %v but has parent %v", next.Title, node.Title, next.Parent.Title)
+			}
+			if next.PrevContinuation != node {
+				return fmt.Errorf("%v continues %v but points back to %v", next.Title, node.Title, titleOf(next.PrevContinuation))
+			}
+			if err := check(next); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if root.Parent != nil || root.NextContinuation != nil || root.PrevContinuation != nil {
+		return fmt.Errorf("root has a parent or continuations")
+	}
+	if err := check(root); err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if !seen[node] {
+			return fmt.Errorf("%v is no longer in the tree", node.Title)
+		}
+	}
+	if len(seen) != len(nodes)+1 {
+		return fmt.Errorf("expected %d nodes in the tree, found %d", len(nodes)+1, len(seen))
+	}
+	return nil
+}
+
+func titleOf(node *AgendaNode) string {
+	if node == nil {
+		return "nil"
+	}
+	return node.Title
+}
+
+// Whether node is inside the subtree of heading, ie. a child, continuation or
+// descendant of either.
+func isWithin(node, heading *AgendaNode) bool {
+	found := false
+	for _, inner := range subtreeHeadings(heading) {
+		if inner == node {
+			found = true
+		}
+	}
+	return found
+}
+
+// Applies operations read from src to the tree, checking its invariants and
+// how Walk order changed after each one.
+func runTreeOperations(src *byteSource, root *AgendaNode) error {
+	var nodes []*AgendaNode
+	root.Walk(func(node *AgendaNode, _ int) {
+		nodes = append(nodes, node)
+	})
+
+	for step := 0; !src.empty(); step++ {
+		before := walkEntries(root)
+		headings := headingsOf(before)
+		if len(headings) == 0 {
+			return nil
+		}
+		subject := headings[src.next(len(headings))]
+		at := indexEntry(before, subject)
+		parent := subject.Parent
+		index := parent.IndexChild(subject)
+		expected := before
+
+		var name string
+		switch src.next(5) {
+		case 0:
+			name = "MakePrevSibling"
+			if index > 0 {
+				expected = moveBlock(before, at, indexEntry(before, parent.Children[index-1]), 0)
+			}
+			subject.MakePrevSibling()
+
+		case 1:
+			name = "MakeNextSibling"
+			if index < len(parent.Children)-1 {
+				next := indexEntry(before, parent.Children[index+1])
+				expected = moveBlock(before, next, at, 0)
+			}
+			subject.MakeNextSibling()
+
+		case 2:
+			name = "MoveUpTree"
+			head := parent
+			for ; head.PrevContinuation != nil; head = head.PrevContinuation {
+			}
+			if head != root {
+				expected = moveBlock(before, at, blockEnd(before, indexEntry(before, head)), -1)
+			}
+			subject.MoveUpTree()
+
+		case 3:
+			name = "MoveDownTree"
+			if index > 0 {
+				end := blockEnd(before, at)
+				expected = append(append(append([]walkEntry{}, before[:at]...), shiftDepth(before[at:end], 1)...), before[end:]...)
+			}
+			subject.MoveDownTree()
+
+		case 4:
+			other := headings[src.next(len(headings))]
+			name = fmt.Sprintf("swap with %v", other.Title)
+			if other == subject || isWithin(other, subject) || isWithin(subject, other) {
+				continue
+			}
+
+			otherParent, otherIndex := other.Parent, other.Parent.IndexChild(other)
+			root.swap(subject, other)
+			if err := checkTree(root, nodes); err != nil {
+				return fmt.Errorf("step %d: %v %v: %v", step, subject.Title, name, err)
+			}
+			if subject.Parent != otherParent || otherParent.IndexChild(subject) != otherIndex {
+				return fmt.Errorf("step %d: %v %v: didn't take the other's place", step, subject.Title, name)
+			}
+			if other.Parent != parent || parent.IndexChild(other) != index {
+				return fmt.Errorf("step %d: %v %v: other didn't take its place", step, subject.Title, name)
+			}
+
+			// Swapping back restores the tree.
+			root.swap(subject, other)
+		}
+
+		if err := checkTree(root, nodes); err != nil {
+			return fmt.Errorf("step %d: %v %v: %v", step, subject.Title, name, err)
+		}
+		if actual := walkEntries(root); formatEntries(actual) != formatEntries(expected) {
+			return fmt.Errorf("step %d: %v %v:\nbefore:\n%v\nexpected:\n%v\ngot:\n%v",
+				step, subject.Title, name, formatEntries(before), formatEntries(expected), formatEntries(actual))
+		}
+	}
+	return nil
+}
+
+func testTreeOperations(t *testing.T, data []byte) {
+	t.Helper()
+	src := &byteSource{data}
+	root := generateTree(src, 1+src.next(20))
+	if err := runTreeOperations(src, root); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSampleTreeOperations(t *testing.T) {
+	for op := 0; op < 5; op++ {
+		for subject := 0; subject < 3; subject++ {
+			for other := 0; other < 3; other++ {
+				src := &byteSource{[]byte{byte(subject), byte(op), byte(other)}}
+				if err := runTreeOperations(src, NewAgendaTree()); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	}
+}
+
+func TestRandomTreeOperations(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		data := make([]byte, 10+random.Intn(200))
+		random.Read(data)
+		testTreeOperations(t, data)
+	}
+}
+
+func FuzzTreeOperations(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{5, 0, 0, 0, 0, 1, 0, 0, 2, 0, 3})
+	f.Add([]byte{12, 2, 0, 0, 1, 2, 1, 0, 2, 3, 4, 2, 3, 4, 5, 2, 4, 1})
+	f.Fuzz(testTreeOperations)
+}
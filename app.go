@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"time"
+)
+
+// AgendaApp is the interactive application: the tree, the pages and modals
+// around it and the actions bound to keys.
+type AgendaApp struct {
+	*tview.Application
+	Root        *AgendaNode
+	Tree        *Tree
+	Modals      *ModalManager
+	Actions     *ActionRegistry
+	Keymap      *Keymap
+	CommandLine *CommandLine
+	help        *tview.TextView
+
+	// The open agenda files, if any.
+	Files []*AgendaFile
+	// Number of backups kept of each file.
+	Backups int
+	// How long after a change unsaved changes are autosaved.
+	AutosaveDelay time.Duration
+	// How often files are checked for changes made outside the app.
+	WatchInterval time.Duration
+}
+
+// Builds the application around root. It doesn't touch the terminal until Run
+// is called, so it can be driven on a tcell.SimulationScreen too.
+func NewAgendaApp(root *AgendaNode) *AgendaApp {
+	mainGrid := tview.NewGrid()
+
+	logView := tview.NewTextView()
+	logView.SetBorder(true)
+	logView.SetTextColor(CurrentTheme.Log)
+	log.SetBackend("panel", NewTextViewLogBackend(logView))
+	log.Debug("Program loaded")
+
+	tree := NewTree(root)
+	tree.SetBorder(true)
+	tree.SetTitle("Agenda")
+
+	flex := tview.NewFlex()
+	flex.SetFullScreen(false)
+	flex.SetDirection(tview.FlexColumn)
+	flex.AddItem(tree, 0, 1, true)
+
+	help := tview.NewTextView()
+	help.SetBorder(true)
+	help.SetTitle("help")
+
+	app := tview.NewApplication()
+	pages := tview.NewPages()
+	modals := &ModalManager{View: TviewModalView{App: app, Pages: pages}}
+	actions := &ActionRegistry{}
+	keymap := DefaultKeymap()
+	var agendaApp *AgendaApp
+
+	status := NewStatusBar()
+
+	mainGrid.SetRows(-1, 1, 5)
+	mainGrid.SetColumns(-1)
+	mainGrid.AddItem(pages, 0, 0, 1, 1, 1, 1, true)
+	mainGrid.AddItem(status, 1, 0, 1, 1, 1, 1, false)
+	mainGrid.AddItem(logView, 2, 0, 1, 1, 1, 1, false)
+
+	closeOnEsc := createEscHandler(func() {
+		modals.Pop()
+	})
+
+	// Shows a transient full-page widget which is discarded again on <esc>.
+	// Global key bindings are suspended meanwhile for widgets taking text input.
+	showPage := func(widget *Widget, takesText bool) {
+		widget.InputHandler = closeOnEsc
+		modals.Push(&Modal{Widget: widget, IsPage: true, TakesText: takesText})
+	}
+
+	// Edits node in a new dialog. A node without a place in the tree yet is
+	// added once the dialog closes, as a child of parent if there is one.
+	editNode := func(parent *AgendaNode, node *AgendaNode) {
+		// New items go where the selection is, unless there is a parent.
+		changed := node
+		if node.Parent == nil && node.NextContinuation == nil && node.PrevContinuation == nil {
+			changed = parent
+			if changed == nil {
+				changed = tree.Selected
+			}
+		}
+		if !tree.editable(changed) {
+			return
+		}
+		widget := NewEditAgendaNodeWidget(app, node, parent)
+		// Typed keys are text, but chords like ctrl+n still reach the global
+		// bindings, eg. to add a child of the edited item.
+		widget.InputHandler = func(event *tcell.EventKey) *tcell.EventKey {
+			if closeOnEsc(event) == nil {
+				return nil
+			}
+			if event.Key() == tcell.KeyRune && event.Modifiers()&tcell.ModAlt == 0 {
+				return event
+			}
+			return modals.Global(event)
+		}
+		title, text, properties := node.Title, node.Text, node.Properties.String()
+		modals.Push(&Modal{Widget: widget, IsPage: true, TakesText: true, State: node, OnPop: func() {
+			AssignID(node)
+			if node.Parent == nil && node.NextContinuation == nil && node.PrevContinuation == nil {
+				switch file := agendaApp.FileOf(tree.Selected); {
+				case parent != nil:
+					parent.AddChild(node)
+				case file != nil:
+					// New items go to the file of the selection.
+					file.Root.AddChild(node)
+					tree.Selected = node
+				default:
+					root.AddChild(node)
+					tree.Selected = node
+				}
+				tree.Journals.Added(node)
+				return
+			}
+			if node.Title != title {
+				tree.Journals.EditedTitle(node)
+			}
+			if node.Text != text {
+				tree.Journals.EditedText(node)
+			}
+			if node.Properties.String() != properties {
+				tree.Journals.EditedHeader(node)
+			}
+		}})
+	}
+
+	for _, action := range []*Action{
+		{Name: "help", Description: "Show this help text.", Scope: ScopeGlobal, Run: func() {
+			if modals.Top().Name == "help" {
+				return
+			}
+			modals.Push(&Modal{Widget: &Widget{Name: "help", Primitive: help, InputHandler: closeOnEsc}, IsPage: true})
+		}},
+		{Name: "add", Description: "Add a new item. While editing, adds a child of the edited item.", Scope: ScopeGlobal, Run: func() {
+			parent, _ := modals.Top().State.(*AgendaNode)
+			editNode(parent, &AgendaNode{})
+		}},
+		{Name: "redraw", Description: "Redraw the screen.", Scope: ScopeGlobal, Run: func() {}},
+		{Name: "messages", Description: "Show all recent log messages.", Scope: ScopeMain, Run: func() {
+			showPage(NewMessagesWidget(log), false)
+		}},
+		{Name: "save", Description: "Save the agenda file.", Scope: ScopeGlobal, Run: func() {
+			if err := agendaApp.Save(); err != nil {
+				log.Error("%v", err)
+			}
+		}},
+		{Name: "palette", Description: "Search for a command to run.", Scope: ScopeGlobal, Run: func() {
+			showPage(NewCommandPaletteWidget(app, actions, keymap), true)
+		}},
+		{Name: "edit", Description: "Edit selected item.", Scope: ScopeMain, Run: func() {
+			if tree.Selected != nil {
+				editNode(nil, tree.Selected)
+			}
+		}},
+	} {
+		actions.Register(action)
+	}
+	tree.RegisterActions(actions)
+	tree.SetSelectedFunc(func(node *AgendaNode) {
+		editNode(nil, node)
+	})
+	var cmdline *CommandLine
+	for _, action := range []*Action{
+		{Name: "command-line", Description: "Enter a command, eg. tag +urgent.", Scope: ScopeMain, Run: func() {
+			widget := NewCommandLineWidget(cmdline)
+			widget.InputHandler = closeOnEsc
+			modals.Push(&Modal{Widget: widget, TakesText: true,
+				OnPush: func() {
+					mainGrid.RemoveItem(status)
+					mainGrid.AddItem(widget.Primitive, 1, 0, 1, 1, 1, 1, true)
+				},
+				OnPop: func() {
+					mainGrid.RemoveItem(widget.Primitive)
+					mainGrid.AddItem(status, 1, 0, 1, 1, 1, 1, false)
+				},
+			})
+		}},
+		{Name: "clock-report", Description: "Show the clock report for the last week. <tab> changes grouping, [ and ] the week.", Scope: ScopeMain, Run: func() {
+			showPage(NewClockReportWidget(root), false)
+		}},
+		{Name: "column-view", Description: "Show the column view of the selected item's subtree.", Scope: ScopeMain, Run: func() {
+			if tree.Selected != nil {
+				edited := func(node *AgendaNode) {
+					AssignID(node)
+					tree.Journals.EditedHeader(node)
+				}
+				if tree.ReadOnly(tree.Selected) {
+					edited = nil
+				}
+				showPage(NewColumnViewWidget(modals, tree.Selected, edited), true)
+			}
+		}},
+	} {
+		actions.Register(action)
+	}
+
+	cmdline = NewCommandLine(tree, actions)
+	cmdline.Register(&Command{
+		Name:  "w",
+		Usage: "w [file]",
+		Run: func(args []string) error {
+			switch len(args) {
+			case 0:
+			case 1:
+				return agendaApp.SaveAs(args[0])
+			default:
+				return fmt.Errorf("Usage: w [file]")
+			}
+			return agendaApp.Save()
+		},
+	})
+	help.SetText(keymap.HelpText(actions))
+
+	modals.Global = func(event *tcell.EventKey) *tcell.EventKey {
+		return keymap.Dispatch(event, &modals.Pending, actions, ScopeGlobal)
+	}
+	modals.Push(&Modal{
+		Widget: &Widget{Name: "main", Primitive: flex, InputHandler: func(event *tcell.EventKey) *tcell.EventKey {
+			return keymap.Dispatch(event, &modals.Pending, actions, ScopeMain)
+		}},
+		IsPage: true,
+		Focus:  tree,
+	})
+
+	modals.Timeout = time.Second
+	modals.OnPending = func() {
+		time.AfterFunc(modals.Timeout, func() {
+			app.QueueUpdateDraw(func() {
+				modals.ExpirePending(time.Now())
+			})
+		})
+	}
+	app.SetInputCapture(modals.HandleKey)
+
+	app.SetRoot(mainGrid, true)
+	app.EnableMouse(true)
+
+	agendaApp = &AgendaApp{
+		Application: app,
+		Root:        root,
+		Tree:        tree,
+		Modals:      modals,
+		Actions:     actions,
+		Keymap:      keymap,
+		CommandLine: cmdline,
+		help:        help,
+
+		Backups:       3,
+		AutosaveDelay: 2 * time.Second,
+		WatchInterval: 2 * time.Second,
+	}
+	tree.ReadOnly = func(node *AgendaNode) bool {
+		file := agendaApp.FileOf(node)
+		return file != nil && file.ReadOnly
+	}
+	app.SetBeforeDrawFunc(func(screen tcell.Screen) bool {
+		agendaApp.scheduleAutosave()
+		status.Update(agendaApp, time.Now())
+		return false
+	})
+	return agendaApp
+}
+
+// Loads bindings from path on top of the current keymap and updates the help
+// page to match.
+func (a *AgendaApp) LoadKeymap(path string) error {
+	if err := a.Keymap.LoadFile(path, a.Actions); err != nil {
+		return err
+	}
+	a.help.SetText(a.Keymap.HelpText(a.Actions))
+	return nil
+}
+
+func (a *AgendaApp) Run() error {
+	// Keep the running clock in the status line and tree current, autosave in
+	// case a change slipped past scheduleAutosave and keep the lock fresh.
+	go func() {
+		for range time.Tick(time.Minute) {
+			a.QueueUpdateDraw(func() {
+				if err := a.Autosave(); err != nil {
+					log.Error("Autosave failed: %v", err)
+				}
+				for _, file := range a.Files {
+					if err := file.lock.Refresh(); err != nil {
+						log.Warn("Couldn't refresh lock: %v", err)
+					}
+				}
+			})
+		}
+	}()
+
+	go a.watch()
+
+	return a.Application.Run()
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Titles of root's headings in rendering order, indented two spaces per level.
+func outline(root *AgendaNode) (lines []string) {
+	root.Walk(func(node *AgendaNode, depth int) {
+		if !node.IsContinuation() {
+			lines = append(lines, fmt.Sprintf("%*s%v", depth*2, "", node.Title))
+		}
+	})
+	return
+}
+
+func assertOutline(t *testing.T, root *AgendaNode, expected ...string) {
+	t.Helper()
+	actual := outline(root)
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected outline:\n%v\ngot:\n%v", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func headingTitled(root *AgendaNode, title string) (found *AgendaNode) {
+	root.Walk(func(node *AgendaNode, _ int) {
+		if !node.IsContinuation() && node.Title == title {
+			found = node
+		}
+	})
+	return
+}
+
+func TestStartsOnTree(t *testing.T) {
+	h := NewHarness(t, NewAgendaTree())
+
+	h.AssertTopModal("main")
+	h.AssertScreenContains("Agenda")
+	h.AssertScreenContains("rc1s1c1")
+}
+
+func TestHelpClosesWithSingleEsc(t *testing.T) {
+	h := NewHarness(t, NewAgendaTree())
+
+	h.Type("?")
+	h.AssertTopModal("help")
+	h.AssertScreenContains("Show this help text.")
+
+	h.Press(tcell.KeyEsc)
+	h.AssertTopModal("main")
+	h.AssertScreenLacks("Show this help text.")
+}
+
+func TestEditDialogClosesWithSingleEsc(t *testing.T) {
+	root := NewAgendaTree()
+	h := NewHarness(t, root)
+
+	h.Press(tcell.KeyEnter)
+	h.AssertScreenContains("Title")
+	h.Type("!")
+
+	h.Press(tcell.KeyEsc)
+	h.AssertTopModal("main")
+	h.AssertScreenContains("rc1!")
+	if root.Children[0].Title != "rc1!" {
+		t.Errorf("Expected title to be edited, got %q", root.Children[0].Title)
+	}
+}
+
+func TestAddItem(t *testing.T) {
+	root := NewAgendaTree()
+	h := NewHarness(t, root)
+
+	h.Type("+new")
+	h.Press(tcell.KeyEsc)
+
+	assertOutline(t, root, "rc1", "  rc1s1c1", "rc2", "new")
+	if h.App.Tree.Selected.Title != "new" {
+		t.Errorf("Expected new item to be selected, got %q", h.App.Tree.Selected.Title)
+	}
+}
+
+func TestAddChildWhileEditingExistingItem(t *testing.T) {
+	root := NewAgendaTree()
+	h := NewHarness(t, root)
+
+	h.Press(tcell.KeyEnter)
+	h.Press(tcell.KeyCtrlN)
+	h.Type("child")
+	h.AssertScreenContains("child of rc1")
+	h.Press(tcell.KeyEsc)
+	if top := h.App.Modals.Top().Name; !strings.HasPrefix(top, "EditAgenda") {
+		t.Errorf("Expected to be back in the edit dialog, got %q", top)
+	}
+	h.Press(tcell.KeyEsc)
+	h.AssertTopModal("main")
+
+	assertOutline(t, root, "rc1", "  child", "  rc1s1c1", "rc2")
+}
+
+func TestMovementAndIndentation(t *testing.T) {
+	root := NewAgendaTree()
+	h := NewHarness(t, root)
+
+	h.Type("G")
+	if h.App.Tree.Selected.Title != "rc2" {
+		t.Fatalf("Expected rc2 to be selected, got %q", h.App.Tree.Selected.Title)
+	}
+
+	h.Alt('l')
+	assertOutline(t, root, "rc1", "  rc1s1c1", "  rc2")
+
+	h.Alt('h')
+	assertOutline(t, root, "rc1", "  rc1s1c1", "rc2")
+
+	h.Type("gg")
+	if h.App.Tree.Selected.Title != "rc1" {
+		t.Errorf("Expected rc1 to be selected, got %q", h.App.Tree.Selected.Title)
+	}
+
+	h.Type("2j")
+	if h.App.Tree.Selected.Title != "rc2" {
+		t.Errorf("Expected rc2 to be selected, got %q", h.App.Tree.Selected.Title)
+	}
+}
+
+func TestPendingKeysShownInStatusLine(t *testing.T) {
+	h := NewHarness(t, NewAgendaTree())
+
+	h.Type("3g")
+	h.AssertScreenContains("[3 g]")
+
+	h.Type("g")
+	h.AssertScreenLacks("[3 g]")
+}
+
+func TestClickSelects(t *testing.T) {
+	h := NewHarness(t, NewAgendaTree())
+
+	h.Click(h.Find("rc2"))
+	if h.App.Tree.Selected.Title != "rc2" {
+		t.Errorf("Expected rc2 to be selected, got %q", h.App.Tree.Selected.Title)
+	}
+}
+
+func TestDoubleClickOpensEditDialog(t *testing.T) {
+	h := NewHarness(t, NewAgendaTree())
+
+	x, y := h.Find("rc2")
+	h.Click(x, y)
+	h.Click(x, y)
+	if top := h.App.Modals.Top().Name; !strings.HasPrefix(top, "EditAgenda") {
+		t.Errorf("Expected the edit dialog on top, got %q", top)
+	}
+}
+
+func TestClickFoldMarkerToggles(t *testing.T) {
+	root := NewAgendaTree()
+	h := NewHarness(t, root)
+
+	h.Type("zc")
+	h.AssertScreenLacks("rc1s1c1")
+
+	x, y := h.Find("...")
+	h.Click(x, y)
+	h.AssertScreenContains("rc1s1c1")
+	if root.Children[0].Folded {
+		t.Error("Expected rc1 to be unfolded")
+	}
+
+	// The marker of the unfolded heading is in the same place.
+	h.AssertScreenContains("rc1 [-]")
+	time.Sleep(tview.DoubleClickInterval)
+	h.Click(x, y)
+	h.AssertScreenLacks("rc1s1c1")
+	if !root.Children[0].Folded {
+		t.Error("Expected rc1 to be folded again")
+	}
+}
+
+func TestDragMovesNode(t *testing.T) {
+	root := NewAgendaTree()
+	h := NewHarness(t, root)
+
+	// Dropped left of rc2's indentation: a sibling after it.
+	x, y := h.Find("rc1s1c1")
+	toX, toY := h.Find("rc2")
+	h.Drag(x, y, toX, toY)
+	assertOutline(t, root, "rc1", "rc2", "rc1s1c1")
+
+	// Dropped right of it: its first child.
+	x, y = h.Find("rc1s1c1")
+	toX, toY = h.Find("rc1")
+	h.Drag(x, y, toX+h.App.Tree.Indent, toY)
+	assertOutline(t, root, "rc1", "  rc1s1c1", "rc2")
+}
+
+func TestColumnViewEscCancelsCellEdit(t *testing.T) {
+	root := NewAgendaTree()
+	h := NewHarness(t, root)
+
+	h.Type("\\c")
+	h.Press(tcell.KeyEnter)
+	if top := h.App.Modals.Top().Name; top != "column-edit" {
+		t.Fatalf("Expected the cell editor on top, got %q", top)
+	}
+	h.Type("dd?x")
+	h.Press(tcell.KeyEsc)
+	if top := h.App.Modals.Top().Name; top != "columns" || root.Children[0].Todo != "" {
+		t.Fatalf("Expected <esc> to cancel the edit and keep the view open, got %q and %q", top, root.Children[0].Todo)
+	}
+
+	h.Press(tcell.KeyEnter)
+	h.Type("todo")
+	h.Press(tcell.KeyEnter)
+	if top := h.App.Modals.Top().Name; top != "columns" || root.Children[0].Todo != "TODO" {
+		t.Errorf("Expected the edit to be saved, got %q and %q", top, root.Children[0].Todo)
+	}
+	assertOutline(t, root, "rc1", "  rc1s1c1", "rc2")
+
+	h.Press(tcell.KeyEsc)
+	if top := h.App.Modals.Top().Name; top == "columns" {
+		t.Errorf("Expected <esc> to close the view")
+	}
+}
+
+func TestLeaderOnlyStartsSequencesOnMainPage(t *testing.T) {
+	h := NewHarness(t, NewAgendaTree())
+
+	h.Type("\\m")
+	h.AssertTopModal("messages")
+	h.Press(tcell.KeyEsc)
+
+	// The leader doesn't start a sequence swallowing the next key elsewhere.
+	h.Type("?\\")
+	h.AssertTopModal("help")
+	if !h.App.Modals.Pending.IsEmpty() {
+		t.Errorf("Expected no pending keys on the help page, got %q", h.App.Modals.Pending.String())
+	}
+}
+
+func TestEditDialogTakesText(t *testing.T) {
+	root := NewAgendaTree()
+	h := NewHarness(t, root)
+
+	h.Press(tcell.KeyEnter)
+	h.Type("a\\b?c+d")
+	if top := h.App.Modals.Top().Name; !strings.HasPrefix(top, "EditAgenda") {
+		t.Fatalf("Expected to stay in the edit dialog, got %q", top)
+	}
+	h.Press(tcell.KeyEsc)
+	h.AssertTopModal("main")
+	assertOutline(t, root, "rc1a\\b?c+d", "  rc1s1c1", "rc2")
+}
+
+func TestTreeColorsDeadlines(t *testing.T) {
+	root := NewNode("", "")
+	day := 24 * time.Hour
+	for _, heading := range []struct {
+		title string
+		due   time.Time
+	}{{"overdue", time.Now().Add(-day)}, {"soon", time.Now().Add(day)}, {"later", time.Now().Add(30 * day)}, {"done", time.Now().Add(-day)}} {
+		node := NewNode(heading.title, "")
+		node.Properties.Set("DEADLINE", heading.due.Format("2006-01-02 15:04"))
+		root.AddChild(node)
+	}
+	root.Children[3].Todo = "DONE"
+
+	screen := tcell.NewSimulationScreen("")
+	screen.Init()
+	screen.SetSize(60, 10)
+	tree := NewTree(root)
+	tree.Selected = nil
+	tree.SetRect(0, 0, 60, 10)
+	tree.Draw(screen)
+
+	theme := tree.Theme
+	expected := map[string]tcell.Color{"overdue": theme.DeadlineOverdue, "soon": theme.DeadlineSoon, "later": theme.Secondary, "done": theme.Secondary}
+	for y, line := range tree.lines {
+		if !line.IsHeading {
+			continue
+		}
+		_, _, style, _ := screen.GetContent(headingWidth(line.Node)-1, y)
+		if color, _, _ := style.Decompose(); color != expected[line.Node.Title] {
+			t.Errorf("Expected the deadline of %v in %v, got %v", line.Node.Title, expected[line.Node.Title], color)
+		}
+	}
+}
+
+func TestColumnTagsRejectsInvalidNames(t *testing.T) {
+	tags := ParseColumns("TAGS")[0]
+	node := NewNode("a", "")
+	if err := tags.Set(node, "work :home:"); err == nil || len(node.Tags) != 0 {
+		t.Errorf("Expected \":home:\" to be rejected, got %v, %v", node.Tags, err)
+	}
+	if err := tags.Set(node, " work  home "); err != nil || tags.Get(node) != "work home" {
+		t.Errorf("Expected the tags to be set, got %q, %v", tags.Get(node), err)
+	}
+}
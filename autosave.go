@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rivo/tview"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Writes data to a temporary file next to path, then renames it over path. A
+// crash at any point leaves either the old or the new contents at path.
+func WriteFileAtomic(path string, data []byte) error {
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func BackupPath(path string, n int) string {
+	return fmt.Sprintf("%v.bak.%d", path, n)
+}
+
+// Shifts path.bak.1 to path.bak.2 and so on, dropping the one past count, and
+// copies path to path.bak.1.
+func RotateBackups(path string, count int) error {
+	if count <= 0 {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	os.Remove(BackupPath(path, count))
+	for n := count - 1; n >= 1; n-- {
+		if err := os.Rename(BackupPath(path, n), BackupPath(path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return WriteFileAtomic(BackupPath(path, 1), data)
+}
+
+// Where unsaved changes to path are autosaved, eg. ".todo.txt.autosave" for
+// "todo.txt".
+func AutosavePath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".autosave")
+}
+
+// Whether the autosave of path is at least as new as path itself, ie. it holds
+// changes that were never saved.
+func HasNewerAutosave(path string) bool {
+	autosave, err := os.Stat(AutosavePath(path))
+	if err != nil {
+		return false
+	}
+	main, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+	return !autosave.ModTime().Before(main.ModTime())
+}
+
+// Saves the file, rotating backups on its first save of the session, and
+// removes its autosave and empties its journal.
+func (file *AgendaFile) Save() error {
+	if file.ReadOnly {
+		return fmt.Errorf("%v is open read-only", file.Path)
+	}
+
+	data := FormatAgenda(file.Root)
+	if !file.backedUp {
+		if err := RotateBackups(file.Path, file.app.Backups); err != nil {
+			return fmt.Errorf("Couldn't back up %v: %v", file.Path, err)
+		}
+		file.backedUp = true
+	}
+	if err := WriteFileAtomic(file.Path, data); err != nil {
+		return err
+	}
+
+	file.saved = string(data)
+	file.autosaved = ""
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		return err
+	}
+	file.setDisk(data, info)
+	if err := os.Remove(AutosavePath(file.Path)); err != nil && !os.IsNotExist(err) {
+		log.Warn("Couldn't remove autosave: %v", err)
+	}
+	if err := file.journal.Reset(data); err != nil {
+		log.Warn("Couldn't compact journal: %v", err)
+	}
+	log.Info("Saved %v", file.Path)
+	return nil
+}
+
+// Writes unsaved changes to the autosave file, if they changed since the last
+// autosave, and restarts the journal from it.
+func (file *AgendaFile) Autosave() error {
+	if file.ReadOnly {
+		return nil
+	}
+
+	data := string(FormatAgenda(file.Root))
+	if data == file.saved || data == file.autosaved {
+		return nil
+	}
+	if err := WriteFileAtomic(AutosavePath(file.Path), []byte(data)); err != nil {
+		return err
+	}
+	file.autosaved = data
+	if err := file.journal.Reset([]byte(data)); err != nil {
+		log.Warn("Couldn't compact journal: %v", err)
+	}
+	log.Debug("Autosaved %v", file.Path)
+	return nil
+}
+
+func (file *AgendaFile) IsDirty() bool {
+	return file.format() != file.saved
+}
+
+// The file as it would be saved. Only formatted again after the tree changed,
+// as it is checked on every draw.
+func (file *AgendaFile) format() string {
+	if revision := file.Root.Revision(); !file.formattedValid || revision != file.formattedRevision {
+		file.formatted = string(FormatAgenda(file.Root))
+		file.formattedRevision, file.formattedValid = revision, true
+	}
+	return file.formatted
+}
+
+// Schedules an autosave AutosaveDelay after the file changes, so bursts of
+// edits are written once.
+func (file *AgendaFile) scheduleAutosave() {
+	if file.ReadOnly || file.autosaveScheduled {
+		return
+	}
+	data := file.format()
+	if data == file.saved || data == file.autosaved {
+		return
+	}
+
+	file.autosaveScheduled = true
+	time.AfterFunc(file.app.AutosaveDelay, func() {
+		file.app.QueueUpdate(func() {
+			file.autosaveScheduled = false
+			if err := file.Autosave(); err != nil {
+				log.Error("Autosave failed: %v", err)
+			}
+		})
+	})
+}
+
+// Loads the file if it exists and locks it. If another instance holds the
+// lock it is opened read-only. If an autosave newer than the file is found the
+// user is asked whether to restore it. Otherwise changes journaled since the
+// file was saved are replayed.
+func (file *AgendaFile) Open() error {
+	info, _ := os.Stat(file.Path)
+	root, data, err := readAgendaFile(file.Path)
+	if os.IsNotExist(err) {
+		root, err = NewNode("", ""), nil
+	}
+	if err != nil {
+		return err
+	}
+
+	file.load(root)
+	file.saved = string(FormatAgenda(file.Root))
+	file.setDisk(data, info)
+
+	file.lock, err = AcquireLock(file.Path)
+	file.ReadOnly = err != nil
+	if err != nil {
+		log.Warn("%v, opening it read-only", err)
+		return nil
+	}
+	file.journal = &Journal{Path: JournalPath(file.Path), Root: file.Root}
+	file.app.Tree.Journals = append(file.app.Tree.Journals, file.journal)
+
+	if HasNewerAutosave(file.Path) {
+		file.offerRecovery(data)
+		return nil
+	}
+	err = file.journal.Replay(file.Root, data)
+	file.app.loaded()
+	return err
+}
+
+// Releases the file's lock and journal.
+func (file *AgendaFile) Close() {
+	file.journal.Close()
+	if err := file.lock.Release(); err != nil {
+		log.Warn("Couldn't release lock: %v", err)
+	}
+	file.lock = nil
+}
+
+// Saves the file to path from now on, locking path instead.
+func (file *AgendaFile) SaveAs(path string) error {
+	if path == file.Path {
+		return file.Save()
+	}
+	lock, err := AcquireLock(path)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	file.Path, file.lock, file.ReadOnly, file.backedUp = path, lock, false, false
+	if file.journal == nil {
+		file.journal = &Journal{Root: file.Root}
+		file.app.Tree.Journals = append(file.app.Tree.Journals, file.journal)
+	}
+	file.journal.Path = JournalPath(path)
+	return file.Save()
+}
+
+func readAgendaFile(path string) (*AgendaNode, []byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := ParseAgenda(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return root, data, nil
+}
+
+// Replaces the contents of the file with root.
+func (file *AgendaFile) load(root *AgendaNode) {
+	file.Root.ReplaceContents(root)
+	file.app.loaded()
+}
+
+// Asks whether to restore the autosave, with the changes journaled since, or
+// to go on from the saved file, whose contents are saved.
+func (file *AgendaFile) offerRecovery(saved []byte) {
+	autosave := AutosavePath(file.Path)
+
+	dialog := tview.NewModal()
+	dialog.SetText(fmt.Sprintf("%v has unsaved changes from an earlier session in %v. Restore them?", file.Path, autosave))
+	dialog.AddButtons([]string{"Restore", "Discard"})
+	dialog.SetDoneFunc(func(_ int, label string) {
+		file.app.Modals.Pop()
+		switch label {
+		case "Restore":
+			root, data, err := readAgendaFile(autosave)
+			if err != nil {
+				log.Error("Couldn't restore: %v", err)
+				break
+			}
+			file.load(root)
+			if err := file.journal.Replay(file.Root, data); err != nil {
+				log.Error("Couldn't replay journal: %v", err)
+			}
+			file.app.loaded()
+			log.Info("Restored unsaved changes from %v", autosave)
+			return
+		case "Discard":
+			if err := os.Remove(autosave); err != nil {
+				log.Error("%v", err)
+			}
+		}
+		if err := file.journal.Reset(saved); err != nil {
+			log.Error("Couldn't start journal: %v", err)
+		}
+	})
+
+	file.app.Modals.Push(&Modal{Widget: &Widget{Name: "recover", Primitive: dialog}, IsPage: true, TakesText: true})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveKeepsBackupsAndRemovesAutosave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agenda.txt")
+	if err := ioutil.WriteFile(path, []byte("* old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewAgendaApp(NewNode("", ""))
+	if err := app.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+	app.Root.AddChild(NewNode("new", ""))
+	if !app.IsDirty() {
+		t.Error("Expected unsaved changes")
+	}
+
+	if err := app.Autosave(); err != nil {
+		t.Fatal(err)
+	}
+	if !HasNewerAutosave(path) {
+		t.Error("Expected a newer autosave")
+	}
+
+	if err := app.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if app.IsDirty() {
+		t.Error("Expected no unsaved changes")
+	}
+	if _, err := os.Stat(AutosavePath(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected autosave to be removed, got %v", err)
+	}
+	if backup, _ := ioutil.ReadFile(BackupPath(path, 1)); string(backup) != "* old\n" {
+		t.Errorf("Expected backup of the old file, got %q", backup)
+	}
+	if saved, _ := ioutil.ReadFile(path); string(saved) != "* old\n* new\n" {
+		t.Errorf("Expected new contents, got %q", saved)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+type ClockInterval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Clock tracks the one AgendaNode currently being timed, if any.
+type Clock struct {
+	Node *AgendaNode
+}
+
+func (interval ClockInterval) IsRunning() bool {
+	return interval.End.IsZero()
+}
+
+// Running intervals are measured up to now.
+func (interval ClockInterval) Duration(now time.Time) time.Duration {
+	if interval.IsRunning() {
+		return now.Sub(interval.Start)
+	}
+	return interval.End.Sub(interval.Start)
+}
+
+func (clock *Clock) In(node *AgendaNode, now time.Time) error {
+	if node == nil {
+		return fmt.Errorf("No node to clock in to")
+	}
+
+	if clock.Node != nil {
+		return fmt.Errorf("Already clocked in to %v", clock.Node.Title)
+	}
+
+	node.Clocks = append(node.Clocks, ClockInterval{Start: now})
+	node.Touch()
+	clock.Node = node
+
+	return nil
+}
+
+func (clock *Clock) Out(now time.Time) error {
+	if clock.Node == nil {
+		return fmt.Errorf("Not clocked in")
+	}
+
+	last := &clock.Node.Clocks[len(clock.Node.Clocks)-1]
+	last.End = now
+	clock.Node.Touch()
+	clock.Node = nil
+
+	return nil
+}
+
+// What the clock is timing, for the status bar, or "" while it is idle.
+func (clock *Clock) Status(now time.Time) string {
+	if clock.Node == nil {
+		return ""
+	}
+
+	last := clock.Node.Clocks[len(clock.Node.Clocks)-1]
+	return fmt.Sprintf("Clocked in: %v [%v]", clock.Node.Title, FormatDuration(last.Duration(now)))
+}
+
+// Total time clocked on node itself.
+func (node *AgendaNode) ClockedTime(now time.Time) (total time.Duration) {
+	for i := range node.Clocks {
+		total += node.Clocks[i].Duration(now)
+	}
+	return
+}
+
+// Total time clocked on node plus every child of node and of its continuations.
+func (node *AgendaNode) SubtreeClockedTime(now time.Time) (total time.Duration) {
+	total = node.ClockedTime(now)
+	for segment := node; segment != nil; segment = segment.NextContinuation {
+		for i := range segment.Children {
+			total += segment.Children[i].SubtreeClockedTime(now)
+		}
+	}
+	return
+}
+
+// Formats d as hours and minutes, eg. "1:05".
+func FormatDuration(d time.Duration) string {
+	minutes := int(d.Round(time.Minute) / time.Minute)
+	return fmt.Sprintf("%d:%02d", minutes/60, minutes%60)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"io"
+	"sort"
+	"time"
+)
+
+type ClockReportGrouping int
+
+const (
+	ReportByNode ClockReportGrouping = iota
+	ReportByTag
+	ReportByDay
+	ReportByWeek
+)
+
+var clockReportGroupingNames = []string{"node", "tag", "day", "week"}
+
+type ClockReportRow struct {
+	Key string
+	// The heading of the row when grouping by node. Key is its outline path.
+	Node  *AgendaNode
+	Total time.Duration
+}
+
+func (grouping ClockReportGrouping) String() string {
+	return clockReportGroupingNames[grouping]
+}
+
+func ParseClockReportGrouping(name string) (ClockReportGrouping, error) {
+	for i := range clockReportGroupingNames {
+		if clockReportGroupingNames[i] == name {
+			return ClockReportGrouping(i), nil
+		}
+	}
+	return ReportByNode, fmt.Errorf("Unknown report grouping %q", name)
+}
+
+// Sums all time clocked in the tree between from and to, grouped as requested.
+// Intervals are clipped to the range. When grouping by day or week, intervals
+// are split at midnight so each day gets only its own share.
+// Rows are in tree order for nodes, alphabetical for tags and chronological
+// otherwise. Nodes get a row each, even if their titles are the same.
+func (root *AgendaNode) ClockReport(grouping ClockReportGrouping, from, to, now time.Time) (rows []ClockReportRow) {
+	totals := map[ClockReportRow]time.Duration{}
+	keys := []ClockReportRow{}
+
+	add := func(key ClockReportRow, d time.Duration) {
+		if _, ok := totals[key]; !ok {
+			keys = append(keys, key)
+		}
+		totals[key] += d
+	}
+
+	root.Walk(func(node *AgendaNode, _ int) {
+		for i := range node.Clocks {
+			start, end := clipInterval(node.Clocks[i], from, to, now)
+			if !start.Before(end) {
+				continue
+			}
+
+			switch grouping {
+			case ReportByNode:
+				add(ClockReportRow{Key: OutlinePath(node), Node: node}, end.Sub(start))
+
+			case ReportByTag:
+				if len(node.Tags) == 0 {
+					add(ClockReportRow{Key: "(untagged)"}, end.Sub(start))
+				}
+				for _, tag := range node.Tags {
+					add(ClockReportRow{Key: tag}, end.Sub(start))
+				}
+
+			case ReportByDay, ReportByWeek:
+				for day := start; day.Before(end); {
+					y, m, d := day.Date()
+					midnight := time.Date(y, m, d+1, 0, 0, 0, 0, day.Location())
+					if midnight.After(end) {
+						midnight = end
+					}
+
+					key := day.Format("2006-01-02")
+					if grouping == ReportByWeek {
+						year, week := day.ISOWeek()
+						key = fmt.Sprintf("%d-W%02d", year, week)
+					}
+					add(ClockReportRow{Key: key}, midnight.Sub(day))
+					day = midnight
+				}
+			}
+		}
+	})
+
+	if grouping != ReportByNode {
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Key < keys[j].Key
+		})
+	}
+
+	for _, key := range keys {
+		key.Total = totals[key]
+		rows = append(rows, key)
+	}
+
+	return
+}
+
+func clipInterval(interval ClockInterval, from, to, now time.Time) (start, end time.Time) {
+	start, end = interval.Start, interval.End
+	if interval.IsRunning() {
+		end = now
+	}
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	return
+}
+
+func WriteClockReportCSV(w io.Writer, grouping ClockReportGrouping, rows []ClockReportRow) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{grouping.String(), "duration", "hours"})
+	for _, row := range rows {
+		out.Write([]string{row.Key, FormatDuration(row.Total), fmt.Sprintf("%.2f", row.Total.Hours())})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Shows the clock report for the last week. <tab> cycles through the groupings,
+// [ and ] step to the week before and after.
+func NewClockReportWidget(root *AgendaNode) (widget *Widget) {
+	widget = &Widget{}
+	grouping := ReportByNode
+	// How many weeks back the report ends.
+	weeksBack := 0
+
+	table := tview.NewTable()
+	table.SetBorder(true)
+	table.SetSelectable(true, false)
+	table.SetFixed(1, 0)
+
+	fill := func() {
+		now := time.Now()
+		to := now.AddDate(0, 0, -7*weeksBack)
+		from := to.AddDate(0, 0, -7)
+		rows := root.ClockReport(grouping, from, to, now)
+
+		table.Clear()
+		table.SetTitle(fmt.Sprintf("Clock report by %v, %v to %v", grouping, from.Format("2006-01-02"), to.Format("2006-01-02")))
+		table.SetCell(0, 0, tview.NewTableCell(grouping.String()).SetSelectable(false).SetTextColor(tview.Styles.SecondaryTextColor))
+		table.SetCell(0, 1, tview.NewTableCell("time").SetSelectable(false).SetTextColor(tview.Styles.SecondaryTextColor))
+
+		var total time.Duration
+		for i, row := range rows {
+			table.SetCellSimple(i+1, 0, tview.Escape(row.Key))
+			table.SetCell(i+1, 1, tview.NewTableCell(FormatDuration(row.Total)).SetAlign(tview.AlignRight))
+			total += row.Total
+		}
+
+		last := len(rows) + 1
+		table.SetCell(last, 0, tview.NewTableCell("total").SetSelectable(false).SetTextColor(tview.Styles.SecondaryTextColor))
+		table.SetCell(last, 1, tview.NewTableCell(FormatDuration(total)).SetSelectable(false).SetAlign(tview.AlignRight))
+	}
+
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyTab:
+			grouping = (grouping + 1) % ClockReportGrouping(len(clockReportGroupingNames))
+		case event.Key() == tcell.KeyRune && event.Rune() == '[':
+			weeksBack++
+		case event.Key() == tcell.KeyRune && event.Rune() == ']' && weeksBack > 0:
+			weeksBack--
+		default:
+			return event
+		}
+		fill()
+		return nil
+	})
+
+	fill()
+
+	widget.Primitive = table
+	widget.Name = "report"
+	return
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClockReportByNode(t *testing.T) {
+	root, err := ParseAgenda(strings.NewReader(`* Work
+  * Review
+    CLOCK: [2020-09-14 09:00:00]--[2020-09-14 10:00:00]
+* Home
+  * Review
+    CLOCK: [2020-09-14 20:00:00]--[2020-09-14 20:30:00]
+    CLOCK: [2020-09-15 20:00:00]--[2020-09-15 20:15:00]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Date(2020, 9, 14, 0, 0, 0, 0, time.Local)
+	rows := root.ClockReport(ReportByNode, from, from.AddDate(0, 0, 7), from)
+	var actual []string
+	for _, row := range rows {
+		actual = append(actual, row.Key+" "+FormatDuration(row.Total))
+	}
+	if expected := "Work > Review 1:00, Home > Review 0:45"; strings.Join(actual, ", ") != expected {
+		t.Errorf("Expected %v, got %v", expected, strings.Join(actual, ", "))
+	}
+	if len(rows) == 2 && (rows[0].Node != root.Children[0].Children[0] || rows[1].Node != root.Children[1].Children[0]) {
+		t.Error("Expected each row to refer to its heading")
+	}
+}
+
+func TestClockReportGroupings(t *testing.T) {
+	root, err := ParseAgenda(strings.NewReader(`* Late :work:
+  CLOCK: [2020-09-13 23:00:00]--[2020-09-14 01:00:00]
+* Both :work:home:
+  CLOCK: [2020-09-15 10:00:00]--[2020-09-15 10:30:00]
+* Untagged
+  CLOCK: [2020-09-20 23:30:00]--[2020-09-21 02:00:00]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clips half an hour off the start of the first interval and everything
+	// after midnight off the last.
+	from := time.Date(2020, 9, 13, 23, 30, 0, 0, time.Local)
+	to := time.Date(2020, 9, 21, 0, 0, 0, 0, time.Local)
+	for grouping, expected := range map[ClockReportGrouping]string{
+		ReportByTag:  "(untagged) 0:30, home 0:30, work 2:00",
+		ReportByDay:  "2020-09-13 0:30, 2020-09-14 1:00, 2020-09-15 0:30, 2020-09-20 0:30",
+		ReportByWeek: "2020-W37 0:30, 2020-W38 2:00",
+	} {
+		var actual []string
+		for _, row := range root.ClockReport(grouping, from, to, to) {
+			actual = append(actual, row.Key+" "+FormatDuration(row.Total))
+		}
+		if strings.Join(actual, ", ") != expected {
+			t.Errorf("Expected by %v %v, got %v", grouping, expected, strings.Join(actual, ", "))
+		}
+	}
+}
+
+func TestClockReportStepsBetweenWeeks(t *testing.T) {
+	h := NewHarness(t, NewAgendaTree())
+	now := time.Now()
+	week := func(back int) string {
+		to := now.AddDate(0, 0, -7*back)
+		return to.AddDate(0, 0, -7).Format("2006-01-02") + " to " + to.Format("2006-01-02")
+	}
+
+	h.Type("\\r")
+	h.AssertTopModal("report")
+	h.AssertScreenContains(week(0))
+	h.Type("[[")
+	h.AssertScreenContains(week(2))
+	h.Type("]")
+	h.AssertScreenContains(week(1))
+	h.Type("]]")
+	h.AssertScreenContains(week(0))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockStatus(t *testing.T) {
+	var clock Clock
+	now := time.Date(2020, 9, 14, 10, 0, 0, 0, time.Local)
+	if status := clock.Status(now); status != "" {
+		t.Errorf("Expected no status while idle, got %q", status)
+	}
+
+	if err := clock.In(NewNode("a", ""), now); err != nil {
+		t.Fatal(err)
+	}
+	if status := clock.Status(now.Add(90 * time.Minute)); status != "Clocked in: a [1:30]" {
+		t.Errorf("Expected the running clock, got %q", status)
+	}
+
+	if err := clock.Out(now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if status := clock.Status(now.Add(time.Hour)); status != "" {
+		t.Errorf("Expected no status after clocking out, got %q", status)
+	}
+}
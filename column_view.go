@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"strings"
+	"time"
+)
+
+// Column specs use org-mode's style: whitespace separated names, each
+// optionally followed by a summary type, eg. "ITEM PRIORITY EFFORT{:}".
+// {:} sums h:mm durations and {+} sums numbers into parent rows.
+// The spec is taken from the (inherited) COLUMNS property of the node the view
+// is opened on, falling back to DefaultColumns.
+const DefaultColumns = "TODO ITEM PRIORITY TAGS EFFORT{:} CLOCKSUM"
+
+type ColumnSummary int
+
+const (
+	SummaryNone ColumnSummary = iota
+	SummaryDuration
+	SummaryNumber
+)
+
+type Column struct {
+	Name    string
+	Summary ColumnSummary
+	Get     func(node *AgendaNode) string
+	// Set is nil for read-only columns.
+	Set func(node *AgendaNode, value string) error
+}
+
+func ParseColumns(spec string) (columns []Column) {
+	for _, field := range strings.Fields(spec) {
+		summary := SummaryNone
+		switch {
+		case strings.HasSuffix(field, "{:}"):
+			summary = SummaryDuration
+		case strings.HasSuffix(field, "{+}"):
+			summary = SummaryNumber
+		}
+		if i := strings.Index(field, "{"); i != -1 {
+			field = field[:i]
+		}
+
+		columns = append(columns, NewColumn(strings.ToUpper(field), summary))
+	}
+	return
+}
+
+func NewColumn(name string, summary ColumnSummary) Column {
+	column := Column{Name: name, Summary: summary}
+
+	switch name {
+	case "ITEM":
+		column.Get = func(node *AgendaNode) string { return node.Title }
+		column.Set = func(node *AgendaNode, value string) error {
+			node.Title = value
+			return nil
+		}
+
+	case "PRIORITY":
+		column.Get = func(node *AgendaNode) string {
+			if node.Priority == 0 {
+				return ""
+			}
+			return string(node.Priority)
+		}
+		column.Set = func(node *AgendaNode, value string) error {
+			value = strings.ToUpper(strings.TrimSpace(value))
+			if value == "" {
+				node.Priority = 0
+				return nil
+			}
+			if len(value) != 1 || priorityRank(rune(value[0])) == -1 {
+				return fmt.Errorf("Unknown priority %q", value)
+			}
+			node.Priority = rune(value[0])
+			return nil
+		}
+
+	case "TODO":
+		column.Get = func(node *AgendaNode) string { return node.Todo }
+		column.Set = func(node *AgendaNode, value string) error {
+			value = strings.ToUpper(strings.TrimSpace(value))
+			if value != "" && todoRank(value) == -1 {
+				return fmt.Errorf("Unknown TODO keyword %q", value)
+			}
+			node.Todo = value
+			return nil
+		}
+
+	case "TAGS":
+		column.Get = func(node *AgendaNode) string { return strings.Join(node.Tags, " ") }
+		column.Set = func(node *AgendaNode, value string) error {
+			tags := strings.Fields(value)
+			for _, tag := range tags {
+				if !ValidTag(tag) {
+					return fmt.Errorf("Invalid tag name %q", tag)
+				}
+			}
+			node.Tags = tags
+			return nil
+		}
+
+	case "CLOCKSUM":
+		// Already rolled up over the subtree, so there is nothing left to sum.
+		column.Summary = SummaryNone
+		column.Get = func(node *AgendaNode) string {
+			total := node.SubtreeClockedTime(time.Now())
+			if total == 0 {
+				return ""
+			}
+			return FormatDuration(total)
+		}
+
+	default:
+		column.Get = func(node *AgendaNode) string {
+			value, _ := node.Properties.Get(name)
+			return value
+		}
+		column.Set = func(node *AgendaNode, value string) error {
+			if !ValidPropertyKey(name) {
+				return fmt.Errorf("Invalid property name %q", name)
+			}
+			if strings.TrimSpace(value) == "" {
+				node.Properties.Delete(name)
+			} else {
+				node.Properties.Set(name, strings.TrimSpace(value))
+			}
+			return nil
+		}
+	}
+
+	return column
+}
+
+// Value of column for node as shown in the view. For summed columns a node with
+// descendants shows the total of its own value and all of theirs.
+func (column Column) Display(node *AgendaNode) string {
+	if column.Summary == SummaryNone || !hasDescendants(node) {
+		return column.Get(node)
+	}
+
+	var duration time.Duration
+	var number float64
+	found := false
+
+	for _, heading := range subtreeHeadings(node) {
+		value := column.Get(heading)
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+
+		switch column.Summary {
+		case SummaryDuration:
+			if d, err := ParseDuration(value); err == nil {
+				duration += d
+				found = true
+			}
+		case SummaryNumber:
+			if n, err := ParseNumber(value); err == nil {
+				number += n
+				found = true
+			}
+		}
+	}
+
+	switch {
+	case !found:
+		return ""
+	case column.Summary == SummaryDuration:
+		return FormatDuration(duration)
+	default:
+		return fmt.Sprintf("%g", number)
+	}
+}
+
+func hasDescendants(node *AgendaNode) bool {
+	for segment := node; segment != nil; segment = segment.NextContinuation {
+		if len(segment.Children) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// node followed by every heading below it, in the order they are rendered.
+func subtreeHeadings(node *AgendaNode) (headings []*AgendaNode) {
+	headings = append(headings, node)
+	for segment := node; segment != nil; segment = segment.NextContinuation {
+		for i := range segment.Children {
+			headings = append(headings, subtreeHeadings(segment.Children[i])...)
+		}
+	}
+	return
+}
+
+// Shows the column view of node's subtree. edited is called with each heading
+// edited in it, and is nil if they can't be edited. Cells are edited in a
+// modal of their own pushed onto modals, so <esc> cancels the edit rather than
+// closing the view.
+func NewColumnViewWidget(modals *ModalManager, node *AgendaNode, edited func(*AgendaNode)) (widget *Widget) {
+	widget = &Widget{}
+
+	spec, ok := node.Property("COLUMNS", true)
+	if !ok {
+		spec = DefaultColumns
+	}
+	columns := ParseColumns(spec)
+
+	depths := map[*AgendaNode]int{}
+	var measure func(*AgendaNode, int)
+	measure = func(n *AgendaNode, depth int) {
+		depths[n] = depth
+		for segment := n; segment != nil; segment = segment.NextContinuation {
+			for i := range segment.Children {
+				measure(segment.Children[i], depth+1)
+			}
+		}
+	}
+	measure(node, 0)
+	rows := subtreeHeadings(node)
+
+	table := tview.NewTable()
+	table.SetBorder(true)
+	table.SetTitle(fmt.Sprintf("Columns: %v (<enter> edits a cell)", node.Title))
+	table.SetSelectable(true, true)
+	table.SetFixed(1, 0)
+
+	field := tview.NewInputField()
+	editor := &Modal{
+		Widget:    &Widget{Name: "column-edit", Primitive: field, InputHandler: createEscHandler(func() { modals.Pop() })},
+		TakesText: true,
+		OnPop: func() {
+			field.SetLabel("")
+			field.SetText("")
+		},
+	}
+
+	fill := func() {
+		for c, column := range columns {
+			table.SetCell(0, c, tview.NewTableCell(column.Name).SetSelectable(false).SetTextColor(tview.Styles.SecondaryTextColor))
+			for r, heading := range rows {
+				text := column.Display(heading)
+				if column.Name == "ITEM" {
+					text = strings.Repeat("  ", depths[heading]) + text
+				}
+				cell := tview.NewTableCell(tview.Escape(text))
+				if column.Summary != SummaryNone {
+					cell.SetAlign(tview.AlignRight)
+				}
+				table.SetCell(r+1, c, cell)
+			}
+		}
+	}
+
+	var editRow, editColumn int
+	table.SetSelectedFunc(func(row, col int) {
+		column := columns[col]
+		switch {
+		case edited == nil:
+			log.Warn("The file is open read-only")
+			return
+		case column.Set == nil:
+			log.Warn("%v is read-only", column.Name)
+			return
+		}
+
+		editRow, editColumn = row, col
+		field.SetLabel(fmt.Sprintf("%v: ", column.Name))
+		field.SetText(column.Get(rows[row-1]))
+		modals.Push(editor)
+	})
+
+	field.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			column := columns[editColumn]
+			if err := column.Set(rows[editRow-1], field.GetText()); err != nil {
+				log.Error("%v", err)
+				return
+			}
+			rows[editRow-1].Touch()
+			edited(rows[editRow-1])
+			fill()
+		}
+		if modals.Top() == editor {
+			modals.Pop()
+		}
+	})
+
+	fill()
+
+	flex := tview.NewFlex()
+	flex.SetDirection(tview.FlexRow)
+	flex.AddItem(table, 0, 1, true)
+	flex.AddItem(field, 1, 0, false)
+
+	widget.Primitive = flex
+	widget.Name = "columns"
+	return
+}
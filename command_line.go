@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"os"
+	"sort"
+	"strings"
+)
+
+const maxHistory = 500
+
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+	// Returns candidates for the last of args. May be nil.
+	Complete func(args []string) []string
+}
+
+// CommandLine runs ex-style commands, eg. `tag +urgent` or `move under "Inbox"`,
+// against the selected node. Every action is available as a command too.
+type CommandLine struct {
+	Commands    []*Command
+	History     []string
+	HistoryFile string
+}
+
+func NewCommandLine(tree *Tree, registry *ActionRegistry) *CommandLine {
+	cmdline := &CommandLine{}
+
+	cmdline.Register(&Command{
+		Name:  "tag",
+		Usage: "tag +name -name ...",
+		Run: func(args []string) error {
+			if tree.Selected == nil {
+				return fmt.Errorf("Nothing selected")
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("Usage: tag +name -name ...")
+			}
+			for _, arg := range args {
+				name := arg
+				if strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-") {
+					name = arg[1:]
+				}
+				if !ValidTag(name) {
+					return fmt.Errorf("Invalid tag name %q", name)
+				}
+			}
+			if !tree.editable(tree.Selected) {
+				return nil
+			}
+			for _, arg := range args {
+				switch {
+				case strings.HasPrefix(arg, "-"):
+					tree.Selected.RemoveTag(arg[1:])
+				default:
+					tree.Selected.AddTag(strings.TrimPrefix(arg, "+"))
+				}
+			}
+			tree.Journals.EditedHeader(tree.Selected)
+			return nil
+		},
+		Complete: func(args []string) (candidates []string) {
+			tags := map[string]bool{}
+			tree.Root.Walk(func(node *AgendaNode, _ int) {
+				for _, tag := range node.Tags {
+					tags[tag] = true
+				}
+			})
+			for tag := range tags {
+				candidates = append(candidates, "+"+tag, "-"+tag)
+			}
+			return
+		},
+	})
+
+	cmdline.Register(&Command{
+		Name:  "move",
+		Usage: `move under "title"`,
+		Run: func(args []string) error {
+			if len(args) != 2 || args[0] != "under" {
+				return fmt.Errorf(`Usage: move under "title"`)
+			}
+			if tree.Selected == nil || tree.Selected.IsContinuation() {
+				return fmt.Errorf("Nothing selected")
+			}
+
+			var targets []*AgendaNode
+			tree.Root.Walk(func(node *AgendaNode, _ int) {
+				if !node.IsContinuation() && strings.EqualFold(node.Title, args[1]) {
+					targets = append(targets, node)
+				}
+			})
+			switch {
+			case len(targets) == 0:
+				return fmt.Errorf("No item titled %q", args[1])
+			case len(targets) > 1:
+				return fmt.Errorf("%d items titled %q", len(targets), args[1])
+			}
+
+			for _, heading := range subtreeHeadings(tree.Selected) {
+				if heading == targets[0] {
+					return fmt.Errorf("Can't move an item under itself")
+				}
+			}
+
+			newParent := targets[0]
+			for ; newParent.NextContinuation != nil; newParent = newParent.NextContinuation {
+			}
+			if !tree.movable(tree.Selected) || !tree.editable(newParent) {
+				return fmt.Errorf("Can't move %v", tree.Selected.Title)
+			}
+			from := PathOf(tree.Selected)
+			tree.Selected.Parent.RemoveChild(tree.Selected)
+			newParent.AddChild(tree.Selected)
+			tree.Journals.Moved(from, tree.Selected)
+			return nil
+		},
+		Complete: func(args []string) (candidates []string) {
+			if len(args) == 1 {
+				return []string{"under"}
+			}
+			tree.Root.Walk(func(node *AgendaNode, _ int) {
+				if !node.IsContinuation() {
+					candidates = append(candidates, node.Title)
+				}
+			})
+			return
+		},
+	})
+
+	cmdline.Register(&Command{
+		Name:  "sort",
+		Usage: "sort priority|title|todo|deadline",
+		Run: func(args []string) error {
+			if tree.Selected == nil {
+				return fmt.Errorf("Nothing selected")
+			}
+			if len(args) != 1 {
+				return fmt.Errorf("Usage: sort priority|title|todo|deadline")
+			}
+			if !tree.editable(tree.Selected) {
+				return nil
+			}
+
+			key, err := ParseSortKey(args[0])
+			if err != nil {
+				return err
+			}
+
+			for segment := tree.Selected; segment != nil; segment = segment.NextContinuation {
+				segment.SortChildren(key)
+			}
+			tree.Journals.Sorted(tree.Selected, key)
+			return nil
+		},
+		Complete: func(args []string) []string {
+			return sortKeyNames
+		},
+	})
+
+	cmdline.Register(&Command{
+		Name:  "find",
+		Usage: "find KEY[=value]",
+		Run: func(args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("Usage: find KEY[=value]")
+			}
+			kv := strings.SplitN(args[0], "=", 2)
+			value := ""
+			if len(kv) == 2 {
+				value = kv[1]
+			}
+
+			// Selects the next match after the selection, wrapping around.
+			found := tree.Root.FindByProperty(kv[0], value, false)
+			if len(found) == 0 {
+				return fmt.Errorf("No item with %v", args[0])
+			}
+			order := map[*AgendaNode]int{}
+			tree.Root.Walk(func(node *AgendaNode, _ int) {
+				order[node] = len(order) + 1
+			})
+			next := 0
+			for i := len(found) - 1; i >= 0 && order[found[i]] > order[tree.Selected]; i-- {
+				next = i
+			}
+			for parent := found[next].Parent; parent != nil; parent = parent.Head().Parent {
+				parent.Head().Folded = false
+			}
+			tree.Selected = found[next]
+			log.Info("%d of %d items with %v", next+1, len(found), args[0])
+			return nil
+		},
+		Complete: func(args []string) (candidates []string) {
+			keys := map[string]bool{}
+			tree.Root.Walk(func(node *AgendaNode, _ int) {
+				for _, key := range node.Properties.Keys {
+					keys[key] = true
+				}
+			})
+			for key := range keys {
+				candidates = append(candidates, key)
+			}
+			return
+		},
+	})
+
+	cmdline.Register(&Command{
+		Name:  "export",
+		Usage: "export format file",
+		Run: func(args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("Usage: export format file")
+			}
+			tree.Root.Walk(func(node *AgendaNode, _ int) {
+				// Headings of read-only files go without an ID.
+				if node.IsContinuation() || (tree.ReadOnly != nil && tree.ReadOnly(node)) {
+					return
+				}
+				if AssignID(node) {
+					tree.Journals.EditedHeader(node)
+				}
+			})
+			if err := Export(tree.Root, args[0], args[1]); err != nil {
+				return err
+			}
+			log.Info("Exported %v to %v", args[0], args[1])
+			return nil
+		},
+		Complete: func(args []string) []string {
+			if len(args) == 1 {
+				return ExportFormats()
+			}
+			return nil
+		},
+	})
+
+	cmdline.Register(&Command{
+		Name:  "import",
+		Usage: "import file.md",
+		Run: func(args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("Usage: import file.md")
+			}
+			if !tree.editable(tree.Selected) {
+				return nil
+			}
+			heading, err := ImportMarkdown(args[0])
+			if err != nil {
+				return err
+			}
+
+			// Goes after the selected item, or into it if that is a file.
+			switch selected := tree.Selected; {
+			case selected == nil:
+				tree.Root.AddChild(heading)
+			case tree.Pinned[selected.Head()]:
+				last := selected.Head()
+				for ; last.NextContinuation != nil; last = last.NextContinuation {
+				}
+				last.AddChild(heading)
+			default:
+				selected = selected.Head()
+				if err := selected.Parent.InsertChild(heading, selected.Parent.IndexChild(selected)+1); err != nil {
+					return err
+				}
+			}
+			tree.Journals.Added(heading)
+			tree.Selected = heading
+			return nil
+		},
+	})
+
+	for _, action := range registry.Actions {
+		action := action
+		cmdline.Register(&Command{
+			Name:  action.Name,
+			Usage: action.Description,
+			Run: func(args []string) error {
+				if len(args) > 0 {
+					return fmt.Errorf("%v takes no arguments", action.Name)
+				}
+				action.Run()
+				return nil
+			},
+		})
+	}
+
+	return cmdline
+}
+
+func (cmdline *CommandLine) Register(command *Command) {
+	cmdline.Commands = append(cmdline.Commands, command)
+}
+
+func (cmdline *CommandLine) Find(name string) *Command {
+	for i := range cmdline.Commands {
+		if cmdline.Commands[i].Name == name {
+			return cmdline.Commands[i]
+		}
+	}
+	return nil
+}
+
+func (cmdline *CommandLine) Execute(line string) error {
+	words, err := SplitCommandLine(line)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	cmdline.addHistory(line)
+
+	command := cmdline.Find(words[0])
+	if command == nil {
+		return fmt.Errorf("Unknown command %q", words[0])
+	}
+	return command.Run(words[1:])
+}
+
+// Completes the last word of line. If there are several candidates line is
+// extended by their common prefix and the candidates are returned as well.
+func (cmdline *CommandLine) Complete(line string) (completed string, candidates []string) {
+	words, err := SplitCommandLine(line)
+	if err != nil {
+		return line, nil
+	}
+	if len(words) == 0 || strings.HasSuffix(line, " ") {
+		words = append(words, "")
+	}
+	last := words[len(words)-1]
+
+	var all []string
+	if len(words) == 1 {
+		for _, command := range cmdline.Commands {
+			all = append(all, command.Name)
+		}
+	} else if command := cmdline.Find(words[0]); command != nil && command.Complete != nil {
+		all = command.Complete(words[1:])
+	}
+
+	for _, candidate := range all {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(last)) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	sort.Strings(candidates)
+	if len(candidates) == 0 {
+		return line, nil
+	}
+
+	prefix := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(prefix) < len(last) {
+		return line, candidates
+	}
+
+	words[len(words)-1] = prefix
+	for i := range words {
+		if strings.ContainsAny(words[i], " \"") {
+			words[i] = fmt.Sprintf("%q", words[i])
+		}
+	}
+	completed = strings.Join(words, " ")
+	if len(candidates) == 1 {
+		completed += " "
+	}
+	return completed, candidates
+}
+
+// Splits line into words at spaces. Double quotes group words, and a backslash
+// escapes the next character inside quotes.
+func SplitCommandLine(line string) (words []string, err error) {
+	var word strings.Builder
+	inWord, quoted, escaped := false, false, false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case r == ' ' && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("Unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return
+}
+
+func (cmdline *CommandLine) addHistory(line string) {
+	if n := len(cmdline.History); n > 0 && cmdline.History[n-1] == line {
+		return
+	}
+	cmdline.History = append(cmdline.History, line)
+	if len(cmdline.History) > maxHistory {
+		cmdline.History = cmdline.History[len(cmdline.History)-maxHistory:]
+	}
+
+	if cmdline.HistoryFile == "" {
+		return
+	}
+	file, err := os.OpenFile(cmdline.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Error("Couldn't save history: %v", err)
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
+
+func (cmdline *CommandLine) LoadHistory(path string) error {
+	cmdline.HistoryFile = path
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			cmdline.History = append(cmdline.History, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(cmdline.History) > maxHistory {
+		// Lines are only ever appended, so the file is trimmed here.
+		cmdline.History = cmdline.History[len(cmdline.History)-maxHistory:]
+		return WriteFileAtomic(path, []byte(strings.Join(cmdline.History, "\n")+"\n"))
+	}
+	return nil
+}
+
+// Single line ":" prompt. <tab> completes, <up>/<down> step through history and
+// <enter> closes the prompt and runs the command.
+func NewCommandLineWidget(cmdline *CommandLine) (widget *Widget) {
+	widget = &Widget{}
+
+	field := tview.NewInputField()
+	field.SetLabel(":")
+	field.SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor)
+
+	historyIndex := len(cmdline.History)
+
+	field.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEnter:
+			line := field.GetText()
+			widget.InputHandler(tcell.NewEventKey(tcell.KeyEsc, 0, tcell.ModNone))
+			if err := cmdline.Execute(line); err != nil {
+				log.Error("%v", err)
+			}
+
+		case tcell.KeyTab:
+			completed, candidates := cmdline.Complete(field.GetText())
+			field.SetText(completed)
+			if len(candidates) > 1 {
+				log.Info("%v", strings.Join(candidates, "  "))
+			}
+		}
+	})
+
+	field.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyUp:
+			if historyIndex > 0 {
+				historyIndex--
+				field.SetText(cmdline.History[historyIndex])
+			}
+			return nil
+		case tcell.KeyDown:
+			if historyIndex < len(cmdline.History)-1 {
+				historyIndex++
+				field.SetText(cmdline.History[historyIndex])
+			} else {
+				historyIndex = len(cmdline.History)
+				field.SetText("")
+			}
+			return nil
+		}
+		return event
+	})
+
+	widget.Primitive = field
+	widget.Name = "command-line"
+	return
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTagCommandRejectsInvalidNames(t *testing.T) {
+	root := NewAgendaTree()
+	app := NewAgendaApp(root)
+	app.Tree.Selected = root.Children[0]
+
+	for _, line := range []string{"tag +", `tag "a b"`, "tag +a:b", "tag +ok -"} {
+		if err := app.CommandLine.Execute(line); err == nil {
+			t.Errorf("Expected %q to be rejected", line)
+		}
+	}
+	if len(root.Children[0].Tags) != 0 {
+		t.Errorf("Expected no tags, got %v", root.Children[0].Tags)
+	}
+	if err := app.CommandLine.Execute("tag +ok urgent"); err != nil || strings.Join(root.Children[0].Tags, " ") != "ok urgent" {
+		t.Errorf("Expected the tags to be added, got %v, %v", root.Children[0].Tags, err)
+	}
+}
+
+func TestLoadHistoryTrimsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history")
+	var lines []string
+	for i := 0; i < maxHistory+10; i++ {
+		lines = append(lines, fmt.Sprintf("tag +t%d", i))
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmdline := NewAgendaApp(NewNode("", "")).CommandLine
+	if err := cmdline.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(cmdline.History) != maxHistory || cmdline.History[0] != "tag +t10" {
+		t.Errorf("Expected the last %d lines, got %d from %q", maxHistory, len(cmdline.History), cmdline.History[0])
+	}
+	data, _ := ioutil.ReadFile(path)
+	if kept := strings.Split(strings.TrimSpace(string(data)), "\n"); len(kept) != maxHistory || kept[0] != "tag +t10" {
+		t.Errorf("Expected the file to be trimmed to %d lines, got %d", maxHistory, len(kept))
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Scores how well pattern matches text as a case-insensitive subsequence.
+// Consecutive matches and matches at the start of words score higher.
+// ok is false if pattern isn't a subsequence of text at all.
+func FuzzyMatch(pattern, text string) (score int, ok bool) {
+	pattern = strings.ToLower(pattern)
+	runes := []rune(strings.ToLower(text))
+
+	t := 0
+	last := -2
+	for _, p := range pattern {
+		for ; t < len(runes) && runes[t] != p; t++ {
+		}
+		if t == len(runes) {
+			return 0, false
+		}
+
+		score++
+		if t == last+1 {
+			score += 2
+		}
+		if t == 0 || !unicode.IsLetter(runes[t-1]) {
+			score += 3
+		}
+		last = t
+		t++
+	}
+
+	return score, true
+}
+
+// Lists every registered action, except the palette itself, filtered by fuzzy
+// matching what is typed. <enter> closes the palette and runs the selected
+// action.
+func NewCommandPaletteWidget(app *tview.Application, registry *ActionRegistry, keymap *Keymap) (widget *Widget) {
+	widget = &Widget{}
+
+	field := tview.NewInputField()
+	field.SetBorder(true)
+	field.SetTitle("Command")
+
+	list := tview.NewList()
+	list.SetBorder(true)
+	list.ShowSecondaryText(true)
+
+	var shown []*Action
+
+	filter := func(pattern string) {
+		type match struct {
+			action *Action
+			score  int
+		}
+		var matches []match
+		for _, action := range registry.Actions {
+			if action.Name == "palette" {
+				continue
+			}
+			if score, ok := FuzzyMatch(pattern, action.Name+" "+action.Description); ok {
+				matches = append(matches, match{action, score})
+			}
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			return matches[i].score > matches[j].score
+		})
+
+		list.Clear()
+		shown = shown[:0]
+		for _, m := range matches {
+			name := m.action.Name
+			sequences := keymap.SequencesFor(name)
+			for i := range sequences {
+				sequences[i] = keymap.expandLeader(sequences[i])
+			}
+			if len(sequences) > 0 {
+				name = fmt.Sprintf("%-20v %v", name, strings.Join(sequences, ", "))
+			}
+			list.AddItem(tview.Escape(name), tview.Escape(m.action.Description), 0, nil)
+			shown = append(shown, m.action)
+		}
+	}
+
+	run := func() {
+		if len(shown) == 0 {
+			return
+		}
+		action := shown[list.GetCurrentItem()]
+		widget.InputHandler(tcell.NewEventKey(tcell.KeyEsc, 0, tcell.ModNone))
+		log.Debug("Running %v", action.Name)
+		action.Run()
+	}
+
+	field.SetChangedFunc(filter)
+	field.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			run()
+		}
+	})
+	field.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyUp, tcell.KeyDown, tcell.KeyPgUp, tcell.KeyPgDn:
+			list.InputHandler()(event, func(p tview.Primitive) {})
+			return nil
+		}
+		return event
+	})
+
+	filter("")
+
+	flex := tview.NewFlex()
+	flex.SetDirection(tview.FlexRow)
+	flex.AddItem(field, 3, 0, true)
+	flex.AddItem(list, 0, 1, false)
+
+	widget.Primitive = flex
+	widget.Name = "palette"
+	return
+}
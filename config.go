@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Config holds the settings read from the user's config file. Each line is
+// "<key> <value>", eg.
+//
+//	# Files, directories and globs, relative to the config file.
+//	agenda ~/notes/work.txt
+//	agenda ~/notes/projects
+//	agenda ~/notes/*.agenda
+//	# The files of directories listed under agenda.
+//	extension .txt
+//	# Key bindings and color theme, like -keymap and -theme.
+//	keymap keymap
+//	theme light
+type Config struct {
+	Agenda    []string
+	Extension string
+	Keymap    string
+	Theme     string
+	// The directory relative agenda entries are resolved against.
+	Dir string
+}
+
+// Where the config file is kept, following the XDG base directory spec.
+func DefaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "go-agenda", "config")
+}
+
+// Reads the config file at path. A missing file is an empty config.
+func LoadConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &Config{Extension: ".txt"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config, err := ParseConfig(file, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return config, nil
+}
+
+func ParseConfig(r io.Reader, dir string) (*Config, error) {
+	config := &Config{Extension: ".txt", Dir: dir}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(text, " ", 2)
+		if len(fields) != 2 || strings.TrimSpace(fields[1]) == "" {
+			return nil, fmt.Errorf("line %d: expected \"<key> <value>\"", line)
+		}
+		key, value := fields[0], strings.TrimSpace(fields[1])
+
+		switch key {
+		case "agenda":
+			config.Agenda = append(config.Agenda, value)
+		case "extension":
+			config.Extension = value
+		case "keymap":
+			config.Keymap = value
+		case "theme":
+			config.Theme = value
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", line, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// The agenda files listed in the config, with directories and globs expanded,
+// each once and in the order listed.
+func (config *Config) AgendaFiles() ([]string, error) {
+	return ExpandAgendaPaths(config.Agenda, config.Dir, config.Extension)
+}
+
+// The keymap file named in the config, or "" if there is none.
+func (config *Config) KeymapFile() (string, error) {
+	if config.Keymap == "" {
+		return "", nil
+	}
+	return expandPath(config.Keymap, config.Dir)
+}
+
+// The theme named in the config, as given to LoadTheme. Defaults to "dark".
+func (config *Config) ThemeName() (string, error) {
+	switch {
+	case config.Theme == "":
+		return "dark", nil
+	case BuiltinTheme(config.Theme) != nil:
+		return config.Theme, nil
+	}
+	return expandPath(config.Theme, config.Dir)
+}
+
+// Paths starting with "~/" are relative to the home directory, other relative
+// ones to dir.
+func expandPath(path, dir string) (string, error) {
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, path[2:]), nil
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(dir, path), nil
+	}
+	return path, nil
+}
+
+// Expands entries naming agenda files, directories and globs to the files
+// they stand for. Directories stand for the files in them ending in
+// extension, skipping hidden ones like autosaves, journals and locks. So do
+// globs, unless they name an extension of their own, eg. "*.agenda". Entries
+// are relative to dir, as in expandPath.
+func ExpandAgendaPaths(entries []string, dir, extension string) (paths []string, err error) {
+	seen := map[string]bool{}
+	add := func(path string) {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, entry := range entries {
+		if entry, err = expandPath(entry, dir); err != nil {
+			return nil, err
+		}
+
+		if strings.ContainsAny(entry, "*?[") {
+			matches, err := filepath.Glob(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", entry, err)
+			}
+			// Hidden files are skipped unless the glob asks for them, and
+			// so are files not ending in extension unless it names its own.
+			pattern := filepath.Base(entry)
+			ext := filepath.Ext(pattern)
+			if ext == "" || strings.ContainsAny(ext, "*?[") {
+				ext = extension
+			}
+			for _, match := range matches {
+				name := filepath.Base(match)
+				if (strings.HasPrefix(name, ".") && !strings.HasPrefix(pattern, ".")) || !strings.HasSuffix(name, ext) {
+					continue
+				}
+				if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+					add(match)
+				}
+			}
+			continue
+		}
+
+		info, err := os.Stat(entry)
+		if err != nil || !info.IsDir() {
+			// Missing files are created on save.
+			add(entry)
+			continue
+		}
+		files, err := ioutil.ReadDir(entry)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, file := range files {
+			// Skips autosaves, journals and locks, which are hidden.
+			if file.Mode().IsRegular() && !strings.HasPrefix(file.Name(), ".") && strings.HasSuffix(file.Name(), extension) {
+				names = append(names, file.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(filepath.Join(entry, name))
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigAgendaFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.txt", "projects/x.txt", "projects/y.txt", "projects/.y.txt.lock", "projects/notes.md", "misc/p.agenda", "misc/q.agenda", "notes/n.txt", "notes/.n.txt.lock", "notes/n.txt.bak.1", "notes/.n.txt.journal"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := ParseConfig(strings.NewReader("# Agendas\nagenda a.txt\nagenda projects\nagenda misc/*.agenda\nagenda notes/*\nagenda a.txt\nagenda missing.txt\n"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := config.AgendaFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, path := range paths {
+		rel, _ := filepath.Rel(dir, path)
+		names = append(names, filepath.ToSlash(rel))
+	}
+	expected := "a.txt projects/x.txt projects/y.txt misc/p.agenda misc/q.agenda notes/n.txt missing.txt"
+	if actual := strings.Join(names, " "); actual != expected {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+
+	config, err = ParseConfig(strings.NewReader("keymap keys\ntheme colors\n"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path, err := config.KeymapFile(); err != nil || path != filepath.Join(dir, "keys") {
+		t.Errorf("Expected the keymap next to the config, got %v, %v", path, err)
+	}
+	if name, err := config.ThemeName(); err != nil || name != filepath.Join(dir, "colors") {
+		t.Errorf("Expected the theme next to the config, got %v, %v", name, err)
+	}
+	for theme, expected := range map[string]string{"": "dark", "light": "light"} {
+		if name, _ := (&Config{Theme: theme, Dir: dir}).ThemeName(); name != expected {
+			t.Errorf("Expected theme %q, got %q", expected, name)
+		}
+	}
+
+	if _, err := ParseConfig(strings.NewReader("agendas a.txt\n"), dir); err == nil {
+		t.Error("Expected an error for an unknown key")
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if path := DefaultConfigPath(); path != filepath.Join(dir, "go-agenda", "config") {
+		t.Errorf("Expected the config below XDG_CONFIG_HOME, got %v", path)
+	}
+}
@@ -15,6 +15,7 @@ func NewEditAgendaNodeWidget(app *tview.Application, node *AgendaNode, scratch *
 
 	title := tview.NewInputField()
 	body := tview.NewInputField()
+	properties := tview.NewInputField()
 
 	titleText := "Title"
 	if scratch != nil {
@@ -26,25 +27,20 @@ func NewEditAgendaNodeWidget(app *tview.Application, node *AgendaNode, scratch *
 	title.SetText(node.Title)
 	title.SetDoneFunc(func(key tcell.Key) {
 		node.Title = title.GetText()
+		node.Touch()
 		switch key {
 		case tcell.KeyEnter:
-			log.Log("<enter>")
 			app.SetFocus(body)
 		case tcell.KeyTab:
-			log.Log("<tab>")
 			app.SetFocus(body)
 		case tcell.KeyEsc:
-			log.Log("<esc>")
 			widget.InputHandler(tcell.NewEventKey(tcell.KeyEsc, 0, tcell.ModNone))
-		case tcell.KeyBacktab:
-			log.Log("<backtab>")
 		default:
 		}
 	})
 	title.SetChangedFunc(func(text string) {
 		node.Title = title.GetText()
-		log.Log(text)
-		app.Draw()
+		node.Touch()
 	})
 
 	body.SetBorder(true)
@@ -52,25 +48,58 @@ func NewEditAgendaNodeWidget(app *tview.Application, node *AgendaNode, scratch *
 	body.SetText(node.Text)
 	body.SetDoneFunc(func(key tcell.Key) {
 		node.Text = body.GetText()
+		node.Touch()
 		switch key {
 		case tcell.KeyEnter:
 			widget.InputHandler(tcell.NewEventKey(tcell.KeyEsc, 0, tcell.ModNone))
-			log.Log("<enter>")
 		case tcell.KeyTab:
-			log.Log("<tab>")
+			app.SetFocus(properties)
 		case tcell.KeyEsc:
-			log.Log("<esc>")
 			widget.InputHandler(tcell.NewEventKey(tcell.KeyEsc, 0, tcell.ModNone))
 		case tcell.KeyBacktab:
-			log.Log("<backtab>")
 			app.SetFocus(title)
 		default:
 		}
 	})
 	body.SetChangedFunc(func(text string) {
 		node.Text = body.GetText()
-		log.Log(text)
-		app.Draw()
+		node.Touch()
+	})
+
+	// Invalid properties are shown in the title and leave the node's alone.
+	propertiesTitle := `Properties (KEY=value; KEY=value, \; for ";")`
+	parseProperties := func() bool {
+		parsed, err := ParseProperties(properties.GetText())
+		if err != nil {
+			properties.SetTitle(fmt.Sprintf("[red]%v", tview.Escape(err.Error())))
+			return false
+		}
+		properties.SetTitle(propertiesTitle)
+		node.Properties = parsed
+		node.Touch()
+		return true
+	}
+
+	properties.SetBorder(true)
+	properties.SetTitle(propertiesTitle)
+	properties.SetText(node.Properties.String())
+	properties.SetDoneFunc(func(key tcell.Key) {
+		valid := parseProperties()
+		switch key {
+		case tcell.KeyEnter:
+			if !valid {
+				return
+			}
+			widget.InputHandler(tcell.NewEventKey(tcell.KeyEsc, 0, tcell.ModNone))
+		case tcell.KeyEsc:
+			widget.InputHandler(tcell.NewEventKey(tcell.KeyEsc, 0, tcell.ModNone))
+		case tcell.KeyBacktab:
+			app.SetFocus(body)
+		default:
+		}
+	})
+	properties.SetChangedFunc(func(text string) {
+		parseProperties()
 	})
 
 	grid := tview.NewGrid()
@@ -79,6 +108,7 @@ func NewEditAgendaNodeWidget(app *tview.Application, node *AgendaNode, scratch *
 
 	grid.AddItem(title, 0, 0, 1, 1, 1, 1, true)
 	grid.AddItem(body, 1, 0, 1, 1, 1, 1, false)
+	grid.AddItem(properties, 2, 0, 1, 1, 1, 1, false)
 
 	widget.Primitive = grid
 	widget.Name = fmt.Sprintf("EditAgenda%v", EditAgendaNodeDialogNum)
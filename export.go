@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Exporters write the tree in other formats, by the name given to the export
+// command and the -export flag.
+var Exporters = map[string]func(w io.Writer, root *AgendaNode) error{
+	"html":     WriteHTML,
+	"ics":      WriteICS,
+	"markdown": WriteMarkdown,
+}
+
+func ExportFormats() (names []string) {
+	for name := range Exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Writes root in format to path, or to stdout for "-". path is left alone if
+// the export fails.
+func Export(root *AgendaNode, format, path string) error {
+	write, ok := Exporters[format]
+	if !ok {
+		return fmt.Errorf("Can't export to %q, expected one of %v", format, strings.Join(ExportFormats(), ", "))
+	}
+
+	var buffer bytes.Buffer
+	if err := write(&buffer, root); err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err := buffer.WriteTo(os.Stdout)
+		return err
+	}
+	return ioutil.WriteFile(path, buffer.Bytes(), 0644)
+}
+
+// Reads the Markdown document at path as one heading. That is its only
+// top-level heading if it has no text before it, or else a heading titled
+// after the file holding all of it.
+func ImportMarkdown(path string) (*AgendaNode, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	root, err := ParseMarkdown(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if root.Text == "" && len(root.Children) == 1 {
+		heading := root.Children[0]
+		root.RemoveChild(heading)
+		return heading, nil
+	}
+
+	heading := NewNode(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), "")
+	heading.ReplaceContents(root)
+	return heading, nil
+}
+
+// Identifies node in exports: its ID property, or else its outline number,
+// eg. "node-2.1" for the first heading below the second top-level one. Outline
+// numbers change as headings are added and moved, so AssignID gives scheduled
+// headings and headings with a deadline an ID property when they are edited
+// or exported from the app. Set one by hand on other headings that are linked
+// to.
+func NodeID(node *AgendaNode) string {
+	if id, ok := node.Properties.Get("ID"); ok && strings.TrimSpace(id) != "" {
+		return strings.Join(strings.Fields(id), "-")
+	}
+
+	var numbers []string
+	for node.Parent != nil {
+		head, _ := segmentPosition(node.Parent)
+		n := node.Parent.IndexChild(node) + 1
+		for segment := node.Parent.PrevContinuation; segment != nil; segment = segment.PrevContinuation {
+			n += len(segment.Children)
+		}
+		numbers = append([]string{fmt.Sprint(n)}, numbers...)
+		node = head
+	}
+	return "node-" + strings.Join(numbers, ".")
+}
+
+// Gives node a new ID property if it is scheduled or has a deadline but has no
+// ID yet. Returns whether it did.
+func AssignID(node *AgendaNode) bool {
+	if id, ok := node.Properties.Get("ID"); ok && strings.TrimSpace(id) != "" {
+		return false
+	}
+	_, scheduled := node.Properties.Get("SCHEDULED")
+	_, deadline := node.Properties.Get("DEADLINE")
+	if !scheduled && !deadline {
+		return false
+	}
+	node.Properties.Set("ID", NewID())
+	node.Touch()
+	return true
+}
+
+// Calls AssignID on every heading below root, returning those given an ID.
+func AssignIDs(root *AgendaNode) (assigned []*AgendaNode) {
+	root.Walk(func(node *AgendaNode, _ int) {
+		if !node.IsContinuation() && AssignID(node) {
+			assigned = append(assigned, node)
+		}
+	})
+	return
+}
+
+// A random UUID, like org-mode uses for IDs.
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
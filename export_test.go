@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportAssignsIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agenda.txt")
+	text := "* a\n  :PROPERTIES:\n  :SCHEDULED: 2020-09-14\n  :END:\n* b\n* c\n  :PROPERTIES:\n  :ID: c\n  :DEADLINE: 2020-09-14\n  :END:\n"
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewAgendaApp(NewNode("", ""))
+	if err := app.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+	output := filepath.Join(dir, "agenda.ics")
+	if err := app.CommandLine.Execute("export ics " + output); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b, c := app.Root.Children[0], app.Root.Children[1], app.Root.Children[2]
+	id, _ := a.Properties.Get("ID")
+	if len(id) != 36 || b.Properties.Len() != 0 || NodeID(c) != "c" {
+		t.Errorf("Expected just a to get an ID, got %q, %v and %v", id, b.Properties.String(), NodeID(c))
+	}
+	if ics, _ := ioutil.ReadFile(output); !strings.Contains(string(ics), "UID:"+id+"-scheduled@go-agenda") {
+		t.Errorf("Expected the new ID in the export, got:\n%s", ics)
+	}
+	if !app.IsDirty() {
+		t.Error("Expected the new ID to be unsaved")
+	}
+
+	// The new ID is journaled like other changes.
+	app.Close()
+	reopened := NewAgendaApp(NewNode("", ""))
+	if err := reopened.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if replayed, _ := reopened.Root.Children[0].Properties.Get("ID"); replayed != id {
+		t.Errorf("Expected ID %q after replaying the journal, got %q", id, replayed)
+	}
+	if AssignID(reopened.Root.Children[0]) {
+		t.Error("Expected the ID to be kept")
+	}
+
+	// A second instance has the file read-only and leaves it without IDs.
+	second := NewAgendaApp(NewNode("", ""))
+	if err := second.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if err := second.CommandLine.Execute("export ics " + output); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := second.Root.Children[0].Properties.Get("ID"); ok || second.IsDirty() {
+		t.Errorf("Expected the read-only file to be left alone, got %q", FormatAgenda(second.Root))
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Harness runs a real AgendaApp on a tcell.SimulationScreen. Keys are injected
+// into the screen and go through the application's own event loop, input
+// capture and focus handling, exactly like keys typed in a terminal.
+type Harness struct {
+	t       *testing.T
+	App     *AgendaApp
+	Screen  tcell.SimulationScreen
+	lines   []string
+	settled chan []string
+}
+
+// Injected after every batch of keys. By the time the event loop gets to it
+// all keys before it have been handled and drawn.
+const settleKey = tcell.KeyF64
+
+func NewHarness(t *testing.T, root *AgendaNode) *Harness {
+	t.Helper()
+
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	screen.SetSize(80, 30)
+
+	h := &Harness{
+		t:       t,
+		App:     NewAgendaApp(root),
+		Screen:  screen,
+		settled: make(chan []string),
+	}
+
+	capture := h.App.GetInputCapture()
+	h.App.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == settleKey {
+			h.settled <- h.screenLines()
+			return nil
+		}
+		return capture(event)
+	})
+
+	h.App.SetScreen(screen)
+	stopped := make(chan error)
+	go func() {
+		stopped <- h.App.Application.Run()
+	}()
+	t.Cleanup(func() {
+		h.App.Stop()
+		if err := <-stopped; err != nil {
+			t.Error(err)
+		}
+	})
+
+	h.settle()
+	return h
+}
+
+func (h *Harness) settle() {
+	h.t.Helper()
+
+	h.Screen.InjectKey(settleKey, 0, tcell.ModNone)
+	select {
+	case h.lines = <-h.settled:
+	case <-time.After(2 * time.Second):
+		h.t.Fatal("Timed out waiting for the application to handle keys")
+	}
+}
+
+// Must only be called from the event loop.
+func (h *Harness) screenLines() []string {
+	cells, width, height := h.Screen.GetContents()
+	lines := make([]string, height)
+	for y := 0; y < height; y++ {
+		var line strings.Builder
+		for x := 0; x < width; x++ {
+			runes := cells[y*width+x].Runes
+			if len(runes) == 0 {
+				line.WriteRune(' ')
+			} else {
+				line.WriteRune(runes[0])
+			}
+		}
+		lines[y] = strings.TrimRight(line.String(), " ")
+	}
+	return lines
+}
+
+// Presses a special key, eg. tcell.KeyEnter.
+func (h *Harness) Press(key tcell.Key) {
+	h.t.Helper()
+	h.Screen.InjectKey(key, 0, tcell.ModNone)
+	h.settle()
+}
+
+func (h *Harness) Alt(r rune) {
+	h.t.Helper()
+	h.Screen.InjectKey(tcell.KeyRune, r, tcell.ModAlt)
+	h.settle()
+}
+
+// Presses and releases the left button at x, y.
+func (h *Harness) Click(x, y int) {
+	h.t.Helper()
+	h.Screen.InjectMouse(x, y, tcell.Button1, tcell.ModNone)
+	h.Screen.InjectMouse(x, y, tcell.ButtonNone, tcell.ModNone)
+	h.settle()
+}
+
+// Presses the left button at x, y, moves to toX, toY and releases it there.
+func (h *Harness) Drag(x, y, toX, toY int) {
+	h.t.Helper()
+	h.Screen.InjectMouse(x, y, tcell.Button1, tcell.ModNone)
+	h.Screen.InjectMouse(toX, toY, tcell.Button1, tcell.ModNone)
+	h.Screen.InjectMouse(toX, toY, tcell.ButtonNone, tcell.ModNone)
+	h.settle()
+}
+
+// Types each rune of text as a separate key.
+func (h *Harness) Type(text string) {
+	h.t.Helper()
+	for _, r := range text {
+		h.Screen.InjectKey(tcell.KeyRune, r, tcell.ModNone)
+	}
+	h.settle()
+}
+
+// The screen as of the last key handled, one string per row.
+func (h *Harness) Lines() []string {
+	return h.lines
+}
+
+// Position of the first occurrence of text on screen.
+func (h *Harness) Find(text string) (x, y int) {
+	h.t.Helper()
+	for y, line := range h.lines {
+		if x := strings.Index(line, text); x != -1 {
+			return len([]rune(line[:x])), y
+		}
+	}
+	h.t.Fatalf("Expected screen to contain %q, got:\n%v", text, strings.Join(h.lines, "\n"))
+	return
+}
+
+func (h *Harness) ScreenContains(text string) bool {
+	for _, line := range h.lines {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Harness) AssertScreenContains(text string) {
+	h.t.Helper()
+	if !h.ScreenContains(text) {
+		h.t.Errorf("Expected screen to contain %q, got:\n%v", text, strings.Join(h.lines, "\n"))
+	}
+}
+
+func (h *Harness) AssertScreenLacks(text string) {
+	h.t.Helper()
+	if h.ScreenContains(text) {
+		h.t.Errorf("Expected screen not to contain %q, got:\n%v", text, strings.Join(h.lines, "\n"))
+	}
+}
+
+func (h *Harness) AssertTopModal(name string) {
+	h.t.Helper()
+	if top := h.App.Modals.Top().Name; top != name {
+		h.t.Errorf("Expected %q on top, got %q", name, top)
+	}
+}
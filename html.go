@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// htmlSection is a heading as rendered by WriteHTML.
+type htmlSection struct {
+	ID         string
+	Title      string
+	Todo       string
+	Done       bool
+	Priority   string
+	Tags       []string
+	Properties [][2]string
+	Open       bool
+	Segments   []htmlSegment
+}
+
+// A segment's text and the headings below it.
+type htmlSegment struct {
+	Text     string
+	Children []*htmlSection
+}
+
+// The headings below all segments of the section.
+func (section *htmlSection) Children() (children []*htmlSection) {
+	for _, segment := range section.Segments {
+		children = append(children, segment.Children...)
+	}
+	return
+}
+
+var htmlTemplate = template.Must(template.New("agenda").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; padding: 0 1em; color: #222; }
+nav ul, section section { margin-left: 1.5em; }
+nav ul { padding-left: 0; list-style: none; }
+nav > ul { margin-left: 0; }
+summary { cursor: pointer; font-weight: bold; margin: 0.4em 0; }
+summary a { color: inherit; text-decoration: none; }
+.text { white-space: pre-wrap; margin: 0.4em 0 0.4em 1.2em; }
+.badge { display: inline-block; font-size: 0.75em; font-weight: normal; border-radius: 0.8em; padding: 0.05em 0.6em; margin-left: 0.3em; vertical-align: middle; }
+.priority { background: #c33; color: #fff; }
+.priority-B { background: #d80; }
+.priority-C { background: #48c; }
+.todo { background: #c33; color: #fff; font-weight: bold; }
+.todo.done { background: #393; }
+.tag { background: #ddd; color: #333; }
+.properties { font-size: 0.85em; color: #555; margin: 0.2em 0 0.2em 1.2em; border-collapse: collapse; }
+.properties th { text-align: left; padding-right: 1em; font-weight: normal; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Text}}<div class="text">{{.}}</div>
+{{end}}{{with .Sections}}<nav>
+<h2>Contents</h2>
+{{template "toc" .}}</nav>
+{{range .}}{{template "section" .}}{{end}}{{end}}</body>
+</html>
+{{define "toc"}}<ul>
+{{range .}}<li><a href="#{{.ID}}">{{.Title}}</a>{{with .Children}}
+{{template "toc" .}}{{end}}</li>
+{{end}}</ul>
+{{end}}
+{{define "section"}}<section id="{{.ID}}">
+<details{{if .Open}} open{{end}}>
+<summary>{{with .Todo}}<span class="badge todo{{if $.Done}} done{{end}}">{{.}}</span> {{end}}<a href="#{{.ID}}">{{.Title}}</a>{{with .Priority}} <span class="badge priority priority-{{.}}">{{.}}</span>{{end}}{{range .Tags}} <span class="badge tag">{{.}}</span>{{end}}</summary>
+{{with .Properties}}<table class="properties">
+{{range .}}<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}</table>
+{{end}}{{range .Segments}}{{with .Text}}<div class="text">{{.}}</div>
+{{end}}{{range .Children}}{{template "section" .}}{{end}}{{end}}</details>
+</section>
+{{end}}`))
+
+// Writes the tree as a self-contained HTML page, for reading in a browser. Each
+// heading is a collapsible section, folded like in the app, with its TODO
+// keyword, priority and tags as badges. A table of contents links to the sections by NodeID.
+func WriteHTML(w io.Writer, root *AgendaNode) error {
+	ids := map[string]int{}
+	var section func(node *AgendaNode) *htmlSection
+	section = func(node *AgendaNode) *htmlSection {
+		id := NodeID(node)
+		// IDs set by hand may repeat.
+		if ids[id]++; ids[id] > 1 {
+			id = fmt.Sprintf("%v-%d", id, ids[id])
+		}
+
+		s := &htmlSection{ID: id, Title: node.Title, Todo: node.Todo, Done: node.IsDone(), Tags: node.Tags, Open: !node.Folded}
+		if node.Priority != 0 {
+			s.Priority = string(node.Priority)
+		}
+		for _, key := range node.Properties.Keys {
+			s.Properties = append(s.Properties, [2]string{key, node.Properties.Values[key]})
+		}
+		for segment := node; segment != nil; segment = segment.NextContinuation {
+			seg := htmlSegment{Text: segment.Text}
+			for _, child := range segment.Children {
+				seg.Children = append(seg.Children, section(child))
+			}
+			s.Segments = append(s.Segments, seg)
+		}
+		return s
+	}
+
+	page := struct {
+		Title    string
+		Text     string
+		Sections []*htmlSection
+	}{Title: strings.TrimSpace(root.Title), Text: root.Text}
+	if page.Title == "" {
+		page.Title = "Agenda"
+	}
+	for segment := root; segment != nil; segment = segment.NextContinuation {
+		for _, child := range segment.Children {
+			page.Sections = append(page.Sections, section(child))
+		}
+	}
+	return htmlTemplate.Execute(w, page)
+}
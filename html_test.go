@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteHTML(t *testing.T) {
+	root := NewAgendaTree()
+	rc1 := root.Children[0]
+	rc1.Title = "Ship <v2>"
+	rc1.Priority = 'A'
+	rc1.Todo = "TODO"
+	rc1.Tags = []string{"work"}
+	rc1.Folded = true
+	rc2 := root.Children[1]
+	rc2.Properties.Set("ID", "release plan")
+	rc2.Todo = "DONE"
+
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, root); err != nil {
+		t.Fatal(err)
+	}
+	page := buf.String()
+
+	for _, expected := range []string{
+		`<a href="#node-1">Ship &lt;v2&gt;</a>`,
+		`<section id="node-1">` + "\n<details>",
+		`<section id="node-1.1">` + "\n<details open>",
+		`<span class="badge priority priority-A">A</span>`,
+		`<span class="badge tag">work</span>`,
+		`<summary><span class="badge todo">TODO</span> <a href="#node-1">`,
+		`<span class="badge todo done">DONE</span>`,
+		`<section id="release-plan">`,
+		`<div class="text">rc1s1c1 rc1s1c1 rc1s1c1</div>`,
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Expected %q in:\n%v", expected, page)
+		}
+	}
+}
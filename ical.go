@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Writes every scheduled heading as an event and every heading with a
+// deadline as a to-do, in the iCalendar format (RFC 5545) calendar apps
+// subscribe to. Events last as long as the heading's EFFORT. UIDs are made from
+// NodeID. Descriptions hold the text of the heading and its continuations.
+// Headings with an invalid SCHEDULED or DEADLINE are left out with a warning.
+func WriteICS(w io.Writer, root *AgendaNode) error {
+	return writeICS(w, root, time.Now())
+}
+
+func writeICS(w io.Writer, root *AgendaNode, now time.Time) error {
+	out := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeICSLine(out, name+":"+value)
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//GrooveStomp//go-agenda//EN")
+
+	root.Walk(func(node *AgendaNode, _ int) {
+		if node.IsContinuation() {
+			return
+		}
+		for _, component := range []struct{ property, name, field string }{
+			{"SCHEDULED", "VEVENT", "DTSTART"},
+			{"DEADLINE", "VTODO", "DUE"},
+		} {
+			value, ok := node.Properties.Get(component.property)
+			if !ok {
+				continue
+			}
+			stamp, err := ParseTimestamp(value)
+			if err != nil {
+				log.Warn("Skipped %v: %v: %v", node.Title, component.property, err)
+				continue
+			}
+
+			line("BEGIN", component.name)
+			line("UID", fmt.Sprintf("%v-%v@go-agenda", NodeID(node), strings.ToLower(component.property)))
+			line("DTSTAMP", now.UTC().Format("20060102T150405Z"))
+			writeICSLine(out, component.field+icsTime(stamp.Time, stamp.HasTime))
+			if effort, ok := node.Properties.Get("EFFORT"); ok && component.name == "VEVENT" && stamp.HasTime {
+				if duration, err := ParseDuration(effort); err == nil && duration > 0 {
+					writeICSLine(out, "DTEND"+icsTime(stamp.Time.Add(duration), true))
+				}
+			}
+			if rrule := stamp.RRule(); rrule != "" {
+				// A recurrence is counted from DTSTART, which to-dos need too.
+				if component.name == "VTODO" {
+					writeICSLine(out, "DTSTART"+icsTime(stamp.Time, stamp.HasTime))
+				}
+				line("RRULE", rrule)
+			}
+			line("SUMMARY", icsText(node.Title))
+			if text := segmentsText(node); text != "" {
+				line("DESCRIPTION", icsText(text))
+			}
+			if len(node.Tags) > 0 {
+				tags := make([]string, len(node.Tags))
+				for i, tag := range node.Tags {
+					tags[i] = icsText(tag)
+				}
+				line("CATEGORIES", strings.Join(tags, ","))
+			}
+			if node.Priority != 0 {
+				// 1 is the highest priority, 9 the lowest.
+				line("PRIORITY", fmt.Sprint(1+4*(node.Priority-Priorities[0])))
+			}
+			line("END", component.name)
+		}
+	})
+
+	line("END", "VCALENDAR")
+	return out.Flush()
+}
+
+// The text of node and its continuations, separated by blank lines.
+func segmentsText(node *AgendaNode) string {
+	var texts []string
+	for segment := node; segment != nil; segment = segment.NextContinuation {
+		if segment.Text != "" {
+			texts = append(texts, segment.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// The value of a date or date-time property, including the ":". Times are
+// floating, ie. in the local time of whoever views the calendar.
+func icsTime(t time.Time, hasTime bool) string {
+	if !hasTime {
+		return ";VALUE=DATE:" + t.Format("20060102")
+	}
+	return ":" + t.Format("20060102T150405")
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+func icsText(text string) string {
+	return icsEscaper.Replace(text)
+}
+
+// Writes a content line, folded after 75 octets without splitting characters.
+func writeICSLine(out *bufio.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		out.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// The space starting continuation lines counts too.
+		limit = 74
+	}
+	out.WriteString(line + "\r\n")
+}
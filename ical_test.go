@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteICS(t *testing.T) {
+	root := NewNode("", "")
+	standup := NewNode("Standup", "Daily; short, sharp")
+	standup.Priority = 'B'
+	standup.Tags = []string{"work"}
+	standup.Properties.Set("ID", "standup")
+	standup.Properties.Set("SCHEDULED", "<2020-09-14 Mon 09:30 +1w>")
+	standup.Properties.Set("EFFORT", "0:15")
+	report := NewNode("Report", "")
+	report.Properties.Set("DEADLINE", "<2020-09-30 Wed +1m>")
+	root.AddChild(standup)
+	root.AddChild(report)
+	root.AddChild(NewNode("Unscheduled", ""))
+	standup.AddContinuation(NewNode("", "After the break"))
+
+	var buf bytes.Buffer
+	if err := writeICS(&buf, root, time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//GrooveStomp//go-agenda//EN",
+		"BEGIN:VEVENT",
+		"UID:standup-scheduled@go-agenda",
+		"DTSTAMP:20200901T120000Z",
+		"DTSTART:20200914T093000",
+		"DTEND:20200914T094500",
+		"RRULE:FREQ=WEEKLY;INTERVAL=1",
+		"SUMMARY:Standup",
+		`DESCRIPTION:Daily\; short\, sharp\n\nAfter the break`,
+		"CATEGORIES:work",
+		"PRIORITY:5",
+		"END:VEVENT",
+		"BEGIN:VTODO",
+		"UID:node-2-deadline@go-agenda",
+		"DTSTAMP:20200901T120000Z",
+		"DUE;VALUE=DATE:20200930",
+		"DTSTART;VALUE=DATE:20200930",
+		"RRULE:FREQ=MONTHLY;INTERVAL=1",
+		"SUMMARY:Report",
+		"END:VTODO",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, buf.String())
+	}
+
+	report.Properties.Set("DEADLINE", "next week")
+	buf.Reset()
+	if err := writeICS(&buf, root, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if written := buf.String(); strings.Contains(written, "Report") || !strings.Contains(written, "SUMMARY:Standup") {
+		t.Errorf("Expected just the heading with an invalid deadline to be left out, got:\n%v", written)
+	}
+}
@@ -1,49 +1,53 @@
 package main
 
 import (
+	"fmt"
 	"github.com/gdamore/tcell"
+	"strings"
+	"time"
 )
 
 type InputHandler func(*tcell.EventKey) *tcell.EventKey
 
-type InputHandlerStack struct {
-	InputHandler []InputHandler
-	Enabled      []bool
+// Keys typed so far towards a multi-key binding, and the count typed before
+// them, eg. "5g" on the way to "5gg".
+// Handlers call Extend or AddDigit when they consume a key without completing
+// a binding. Otherwise Pending is reset once any handler consumes the key.
+type PendingKeys struct {
+	Count    int
+	Keys     []string
+	Since    time.Time
+	extended bool
 }
 
-func (s *InputHandlerStack) Push(f InputHandler) int {
-	s.InputHandler = append(s.InputHandler, f)
-	s.Enabled = append(s.Enabled, true)
-	return len(s.InputHandler) - 1
+func (pending *PendingKeys) Extend(chord string) {
+	pending.Keys = append(pending.Keys, chord)
+	pending.extended = true
 }
 
-func (s *InputHandlerStack) Pop() (handler InputHandler) {
-	handler = s.Top()
-	if handler == nil {
-		return
-	}
-
-	s.InputHandler = s.InputHandler[:len(s.InputHandler)-1]
-
-	return handler
+func (pending *PendingKeys) AddDigit(digit int) {
+	pending.Count = pending.Count*10 + digit
+	pending.extended = true
 }
 
-func (s *InputHandlerStack) Disable(idx int) {
-	s.Enabled[idx] = false
+func (pending *PendingKeys) Reset() {
+	*pending = PendingKeys{}
 }
 
-func (s *InputHandlerStack) Enable(idx int) {
-	s.Enabled[idx] = true
+func (pending *PendingKeys) IsEmpty() bool {
+	return pending.Count == 0 && len(pending.Keys) == 0
 }
 
-func (s *InputHandlerStack) Top() InputHandler {
-	if len(s.InputHandler) < 1 {
-		return nil
+func (pending *PendingKeys) String() string {
+	if pending.IsEmpty() {
+		return ""
 	}
 
-	handler := s.InputHandler[len(s.InputHandler)-1]
-
-	return handler
+	count := ""
+	if pending.Count > 0 {
+		count = fmt.Sprintf("%d", pending.Count)
+	}
+	return strings.TrimSpace(count + " " + strings.Join(pending.Keys, " "))
 }
 
 func createEscHandler(callback func()) InputHandler {
@@ -57,20 +61,3 @@ func createEscHandler(callback func()) InputHandler {
 		return eventKey
 	}
 }
-
-func createAppInputHandler(stack *InputHandlerStack) InputHandler {
-	return func(event *tcell.EventKey) *tcell.EventKey {
-		result := event
-		for i := len(stack.InputHandler) - 1; i >= 0; i-- {
-			handler := stack.InputHandler[i]
-			if !stack.Enabled[i] {
-				continue
-			}
-			res := handler(event)
-			if res == nil {
-				return nil
-			}
-		}
-		return result
-	}
-}
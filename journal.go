@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PathStep leads from a node to one of its children: the index of the
+// continuation segment holding the child, 0 for the node itself, and the
+// child's index in that segment.
+type PathStep [2]int
+
+// The steps from the root to a heading. Paths are only meaningful for the tree
+// they were taken from, which is why a journal is replayed on the exact
+// snapshot it was started from.
+type NodePath []PathStep
+
+func PathOf(node *AgendaNode) (path NodePath) {
+	for node.Parent != nil {
+		parent, n := segmentPosition(node.Parent)
+		path = append(NodePath{{n, node.Parent.IndexChild(node)}}, path...)
+		node = parent
+	}
+	return
+}
+
+func (path NodePath) Equal(other NodePath) bool {
+	if len(path) != len(other) {
+		return false
+	}
+	for i := range path {
+		if path[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Finds the heading at path below root. The empty path is root itself.
+func (root *AgendaNode) Resolve(path NodePath) (*AgendaNode, error) {
+	node := root
+	for _, step := range path {
+		segment, err := node.Segment(step[0])
+		if err != nil {
+			return nil, err
+		}
+		if step[1] < 0 || step[1] >= len(segment.Children) {
+			return nil, fmt.Errorf("No child %d in %v", step[1], path)
+		}
+		node = segment.Children[step[1]]
+	}
+	return node, nil
+}
+
+// The nth segment of node, where 0 is node itself.
+func (node *AgendaNode) Segment(n int) (*AgendaNode, error) {
+	segment := node
+	for i := 0; i < n && segment != nil; i++ {
+		segment = segment.NextContinuation
+	}
+	if segment == nil || n < 0 {
+		return nil, fmt.Errorf("No segment %d of %v", n, node.Title)
+	}
+	return segment, nil
+}
+
+// The heading owning segment and the index of segment in its chain.
+func segmentPosition(segment *AgendaNode) (*AgendaNode, int) {
+	n := 0
+	for ; segment.PrevContinuation != nil; segment = segment.PrevContinuation {
+		n++
+	}
+	return segment, n
+}
+
+// One change to the tree. Each is applied to the tree as left by the previous.
+type JournalEntry struct {
+	Time time.Time `json:"time"`
+	// base, add, remove, move, edit or sort.
+	Op string `json:"op"`
+	// The node changed.
+	Node NodePath `json:"node,omitempty"`
+	// Where the node is added or moved to: a segment of the heading at Parent,
+	// and the index among its children.
+	Parent  NodePath `json:"parent,omitempty"`
+	Segment int      `json:"segment,omitempty"`
+	Index   int      `json:"index,omitempty"`
+	// title or text for edits, priority or title for sorts.
+	Field string `json:"field,omitempty"`
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text,omitempty"`
+	// For adds, the node and everything below it in the agenda file format.
+	Agenda string `json:"agenda,omitempty"`
+	// For base entries, the hash of the snapshot the journal applies to.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Journal appends changes to the tree to a file next to the agenda as they are
+// made, so they survive a crash before the next autosave. It is restarted from
+// each autosave or save. All methods do nothing on a nil Journal.
+//
+// Adding, removing, moving and sorting headings and editing them is journaled:
+// titles and text, and as a whole their priority, tags, properties and clocks.
+// Only folding isn't, as it isn't saved.
+type Journal struct {
+	Path string
+	// The root of the journaled file, which paths are relative to. Changes to
+	// nodes not below it aren't recorded, eg. to children of an item being
+	// added, which are recorded along with the item.
+	Root *AgendaNode
+	file *os.File
+}
+
+// Where changes to the agenda at path are journaled.
+func JournalPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".journal")
+}
+
+func snapshotHash(snapshot []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(snapshot))
+}
+
+func ReadJournal(path string) (entries []JournalEntry, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A crash can leave the last line incomplete.
+			log.Warn("%v: ignoring line %d and after: %v", path, line, err)
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Empties the journal and starts it from snapshot.
+func (journal *Journal) Reset(snapshot []byte) error {
+	if journal == nil {
+		return nil
+	}
+	journal.Close()
+
+	base, err := json.Marshal(JournalEntry{Time: time.Now(), Op: "base", Hash: snapshotHash(snapshot)})
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(journal.Path, append(base, '\n')); err != nil {
+		return err
+	}
+
+	journal.file, err = os.OpenFile(journal.Path, os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+// Replays the journal onto root, if it was started from snapshot. Otherwise,
+// or if there is no journal yet, starts it from snapshot.
+func (journal *Journal) Replay(root *AgendaNode, snapshot []byte) error {
+	if journal == nil {
+		return nil
+	}
+
+	entries, err := ReadJournal(journal.Path)
+	if os.IsNotExist(err) || (err == nil && len(entries) == 0) {
+		return journal.Reset(snapshot)
+	}
+	if err != nil {
+		return err
+	}
+	if entries[0].Op != "base" || entries[0].Hash != snapshotHash(snapshot) {
+		log.Warn("%v was started from another version of the agenda, ignoring it", journal.Path)
+		return journal.Reset(snapshot)
+	}
+
+	// Tried on a copy first, so a broken journal leaves root as it was saved.
+	scratch, err := ParseAgenda(bytes.NewReader(snapshot))
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries[1:] {
+		if err := scratch.Apply(entry); err != nil {
+			return journal.setAside(fmt.Errorf("entry %d: %v", i+2, err), snapshot)
+		}
+	}
+	for _, entry := range entries[1:] {
+		if err := root.Apply(entry); err != nil {
+			return err
+		}
+	}
+	if len(entries) > 1 {
+		log.Info("Replayed %d changes from %v", len(entries)-1, journal.Path)
+	}
+
+	journal.file, err = os.OpenFile(journal.Path, os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+// Moves a journal that can't be replayed out of the way, keeping it for a
+// look by hand, and starts a new one from snapshot.
+func (journal *Journal) setAside(cause error, snapshot []byte) error {
+	broken := fmt.Sprintf("%v.%v", journal.Path, time.Now().Format("20060102-150405"))
+	if err := os.Rename(journal.Path, broken); err != nil {
+		return err
+	}
+	log.Warn("Couldn't replay %v (%v), keeping the saved agenda and moving the journal to %v", journal.Path, cause, broken)
+	return journal.Reset(snapshot)
+}
+
+func (journal *Journal) Close() {
+	if journal == nil || journal.file == nil {
+		return
+	}
+	journal.file.Close()
+	journal.file = nil
+}
+
+// Whether node is root or below it.
+func (root *AgendaNode) Contains(node *AgendaNode) bool {
+	for node != root {
+		if node.Parent == nil {
+			return false
+		}
+		node = node.Parent.Head()
+	}
+	return true
+}
+
+// The path of node below the journaled root, if it is below it.
+func (journal *Journal) pathOf(node *AgendaNode) (NodePath, bool) {
+	if !journal.Root.Contains(node) {
+		return nil, false
+	}
+	return journal.relative(PathOf(node))
+}
+
+// path made relative to the journaled root, if it leads to the root or below.
+func (journal *Journal) relative(path NodePath) (NodePath, bool) {
+	prefix := PathOf(journal.Root)
+	if len(path) < len(prefix) || !path[:len(prefix)].Equal(prefix) {
+		return nil, false
+	}
+	return path[len(prefix):], true
+}
+
+func (journal *Journal) record(entry JournalEntry) {
+	if journal.file == nil {
+		return
+	}
+
+	entry.Time = time.Now()
+	data, err := json.Marshal(entry)
+	if err == nil {
+		_, err = journal.file.Write(append(data, '\n'))
+	}
+	if err == nil {
+		err = journal.file.Sync()
+	}
+	if err != nil {
+		log.Error("Couldn't journal %v: %v", entry.Op, err)
+	}
+}
+
+// Records that node was added where it is now, along with everything below it.
+func (journal *Journal) Added(node *AgendaNode) {
+	if journal == nil || node == journal.Root {
+		return
+	}
+	parent, segment := segmentPosition(node.Parent)
+	path, ok := journal.pathOf(parent)
+	if !ok {
+		return
+	}
+	journal.record(JournalEntry{Op: "add", Parent: path, Segment: segment, Index: node.Parent.IndexChild(node), Agenda: subtreeContent(node)})
+}
+
+// Records that node is about to be removed.
+func (journal *Journal) Removed(node *AgendaNode) {
+	if journal == nil || node == journal.Root {
+		return
+	}
+	if path, ok := journal.pathOf(node); ok {
+		journal.record(JournalEntry{Op: "remove", Node: path})
+	}
+}
+
+// Records that node moved from path to where it is now. A node moved in from
+// outside the journaled root is recorded as added, one moved out as removed.
+func (journal *Journal) Moved(from NodePath, node *AgendaNode) {
+	if journal == nil || node == journal.Root {
+		return
+	}
+	from, wasBelow := journal.relative(from)
+	wasBelow = wasBelow && len(from) > 0
+	to, isBelow := journal.pathOf(node)
+
+	switch {
+	case wasBelow && isBelow:
+		if from.Equal(to) {
+			return
+		}
+		parent, segment := segmentPosition(node.Parent)
+		path, _ := journal.pathOf(parent)
+		journal.record(JournalEntry{Op: "move", Node: from, Parent: path, Segment: segment, Index: node.Parent.IndexChild(node)})
+	case wasBelow:
+		journal.record(JournalEntry{Op: "remove", Node: from})
+	case isBelow:
+		journal.Added(node)
+	}
+}
+
+func (journal *Journal) EditedTitle(node *AgendaNode) {
+	if journal == nil {
+		return
+	}
+	if path, ok := journal.pathOf(node); ok && len(path) > 0 {
+		journal.record(JournalEntry{Op: "edit", Node: path, Field: "title", Title: node.Title})
+	}
+}
+
+func (journal *Journal) EditedText(node *AgendaNode) {
+	if journal == nil {
+		return
+	}
+	if path, ok := journal.pathOf(node); ok && len(path) > 0 {
+		journal.record(JournalEntry{Op: "edit", Node: path, Field: "text", Text: node.Text})
+	}
+}
+
+// Records the heading line, properties and clocks of node, eg. after its
+// priority, tags or properties changed.
+func (journal *Journal) EditedHeader(node *AgendaNode) {
+	if journal == nil {
+		return
+	}
+	node = node.Head()
+	if path, ok := journal.pathOf(node); ok && len(path) > 0 {
+		header := subtreeContent(headerOf(node))
+		// A header that wouldn't parse back would break every later replay.
+		if _, err := parseHeader(header); err != nil {
+			log.Error("Not journaling the edit of %v: %v", node.Title, err)
+			return
+		}
+		journal.record(JournalEntry{Op: "edit", Node: path, Field: "header", Agenda: header})
+	}
+}
+
+// A copy of node without its text, continuations and children.
+func headerOf(node *AgendaNode) *AgendaNode {
+	return &AgendaNode{Title: node.Title, Todo: node.Todo, Priority: node.Priority, Tags: node.Tags, Properties: node.Properties, Clocks: node.Clocks}
+}
+
+// Parses the single heading of a journaled header.
+func parseHeader(agenda string) (*AgendaNode, error) {
+	parsed, err := ParseAgenda(strings.NewReader(agenda))
+	if err != nil {
+		return nil, err
+	}
+	if len(parsed.Children) != 1 {
+		return nil, fmt.Errorf("Expected one heading, got %d", len(parsed.Children))
+	}
+	return parsed.Children[0], nil
+}
+
+// Records that the children of every segment of node were sorted by key.
+func (journal *Journal) Sorted(node *AgendaNode, key SortKey) {
+	if journal == nil {
+		return
+	}
+	if path, ok := journal.pathOf(node); ok {
+		journal.record(JournalEntry{Op: "sort", Node: path, Field: key.String()})
+	}
+}
+
+// Journals are the journals of all open files. Each change is recorded by the
+// journal of the file it concerns, a move between files by both.
+type Journals []*Journal
+
+func (journals Journals) Added(node *AgendaNode) {
+	for _, journal := range journals {
+		journal.Added(node)
+	}
+}
+
+func (journals Journals) Removed(node *AgendaNode) {
+	for _, journal := range journals {
+		journal.Removed(node)
+	}
+}
+
+func (journals Journals) Moved(from NodePath, node *AgendaNode) {
+	for _, journal := range journals {
+		journal.Moved(from, node)
+	}
+}
+
+func (journals Journals) EditedTitle(node *AgendaNode) {
+	for _, journal := range journals {
+		journal.EditedTitle(node)
+	}
+}
+
+func (journals Journals) EditedText(node *AgendaNode) {
+	for _, journal := range journals {
+		journal.EditedText(node)
+	}
+}
+
+func (journals Journals) EditedHeader(node *AgendaNode) {
+	for _, journal := range journals {
+		journal.EditedHeader(node)
+	}
+}
+
+func (journals Journals) Sorted(node *AgendaNode, key SortKey) {
+	for _, journal := range journals {
+		journal.Sorted(node, key)
+	}
+}
+
+// Applies a journaled change to the tree below root.
+func (root *AgendaNode) Apply(entry JournalEntry) error {
+	var node *AgendaNode
+	if entry.Op != "add" {
+		var err error
+		if node, err = root.Resolve(entry.Node); err != nil {
+			return err
+		}
+		if node == root {
+			return fmt.Errorf("Can't %v the root", entry.Op)
+		}
+	}
+
+	// Where an added or moved node goes. Resolved after a moved node is taken
+	// out, as it was when the move was recorded.
+	insert := func(node *AgendaNode) error {
+		parent, err := root.Resolve(entry.Parent)
+		if err != nil {
+			return err
+		}
+		segment, err := parent.Segment(entry.Segment)
+		if err != nil {
+			return err
+		}
+		return segment.InsertChild(node, entry.Index)
+	}
+
+	switch entry.Op {
+	case "add":
+		added, err := ParseAgenda(strings.NewReader(entry.Agenda))
+		if err != nil {
+			return err
+		}
+		if len(added.Children) != 1 {
+			return fmt.Errorf("Expected one heading to add, got %d", len(added.Children))
+		}
+		return insert(added.Children[0])
+	case "remove":
+		node.Parent.RemoveChild(node)
+	case "move":
+		node.Parent.RemoveChild(node)
+		return insert(node)
+	case "edit":
+		switch entry.Field {
+		case "title":
+			node.Title = entry.Title
+		case "text":
+			node.Text = entry.Text
+		case "header":
+			header, err := parseHeader(entry.Agenda)
+			if err != nil {
+				return err
+			}
+			node.Title, node.Todo, node.Priority, node.Tags = header.Title, header.Todo, header.Priority, header.Tags
+			node.Properties, node.Clocks = header.Properties, header.Clocks
+		default:
+			return fmt.Errorf("Can't edit %q", entry.Field)
+		}
+		node.Touch()
+	case "sort":
+		key, err := ParseSortKey(entry.Field)
+		if err != nil {
+			return err
+		}
+		for segment := node; segment != nil; segment = segment.NextContinuation {
+			segment.SortChildren(key)
+		}
+	default:
+		return fmt.Errorf("Unknown operation %q", entry.Op)
+	}
+	return nil
+}
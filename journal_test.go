@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalReplaysUnsavedChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agenda.txt")
+	if err := ioutil.WriteFile(path, []byte("* a\n* b\n  * b2\n  * b1\n* c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewAgendaApp(NewNode("", ""))
+	if err := app.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	run := func(title, action string) {
+		app.Tree.Selected = headingTitled(app.Root, title)
+		app.Actions.Find(action).Run()
+	}
+	run("c", "indent")
+	run("c", "move-up")
+	run("b", "sort-title")
+	run("a", "delete")
+	b1 := headingTitled(app.Root, "b1")
+	b1.Title = "b one"
+	b1.Touch()
+	app.Tree.Journals.EditedTitle(b1)
+	added := NewNode("d", "text of d")
+	added.AddChild(NewNode("d1", ""))
+	app.Root.AddChild(added)
+	app.Tree.Journals.Added(added)
+	expected := string(FormatAgenda(app.Root))
+
+	// As if the session crashed before autosaving.
+	app.Close()
+	reopened := NewAgendaApp(NewNode("", ""))
+	if err := reopened.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(FormatAgenda(reopened.Root)); actual != expected {
+		t.Errorf("Expected replayed agenda\n%v\ngot\n%v", expected, actual)
+	}
+
+	if err := reopened.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := ReadJournal(JournalPath(path)); err != nil || len(entries) != 1 {
+		t.Errorf("Expected just the base of the journal after saving, got %v, %v", entries, err)
+	}
+	reopened.Close()
+
+	// A journal of another version of the file is ignored.
+	if err := ioutil.WriteFile(path, []byte("* x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed := NewAgendaApp(NewNode("", ""))
+	if err := changed.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	changed.Tree.Journals.Removed(changed.Root.Children[0])
+	changed.Close()
+	if err := ioutil.WriteFile(path, []byte("* y\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := changed.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer changed.Close()
+	if actual := string(FormatAgenda(changed.Root)); actual != "* y\n" {
+		t.Errorf("Expected the journal to be ignored, got %q", actual)
+	}
+}
+
+func TestJournalReplaysHeaderChangesBeforeSorts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agenda.txt")
+	if err := ioutil.WriteFile(path, []byte("* p\n  * a\n  * b\n  * c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewAgendaApp(NewNode("", ""))
+	if err := app.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	run := func(title, action string) {
+		app.Tree.Selected = headingTitled(app.Root, title)
+		app.Actions.Find(action).Run()
+	}
+	run("c", "cycle-priority")
+	run("b", "cycle-priority")
+	run("b", "cycle-priority")
+	run("a", "cycle-todo")
+	run("a", "cycle-todo")
+	run("c", "cycle-todo")
+	if err := app.CommandLine.Execute("tag +urgent"); err != nil {
+		t.Fatal(err)
+	}
+	run("b", "clock-in")
+	run("p", "sort-priority")
+	run("p", "sort-todo")
+	expected := string(FormatAgenda(app.Root))
+
+	app.Close()
+	reopened := NewAgendaApp(NewNode("", ""))
+	if err := reopened.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if actual := string(FormatAgenda(reopened.Root)); actual != expected {
+		t.Errorf("Expected replayed agenda\n%v\ngot\n%v", expected, actual)
+	}
+	if reopened.Tree.Clock.Node != headingTitled(reopened.Root, "b") {
+		t.Error("Expected the running clock to be replayed")
+	}
+}
+
+func TestJournalThatFailsToReplayIsSetAside(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agenda.txt")
+	if err := ioutil.WriteFile(path, []byte("* a\n* b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewAgendaApp(NewNode("", ""))
+	if err := app.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	a := headingTitled(app.Root, "a")
+	a.Properties.Set("MY KEY", "1")
+	app.Tree.Journals.EditedHeader(a)
+	if entries, err := ReadJournal(JournalPath(path)); err != nil || len(entries) != 1 {
+		t.Errorf("Expected a header that won't parse back not to be journaled, got %v, %v", entries, err)
+	}
+	a.Title = "a one"
+	app.Tree.Journals.EditedTitle(a)
+	app.Tree.Journals[0].record(JournalEntry{Op: "remove", Node: NodePath{{0, 7}}})
+	app.Close()
+
+	reopened := NewAgendaApp(NewNode("", ""))
+	if err := reopened.Open(path); err != nil {
+		t.Fatalf("Expected a broken journal not to keep the file from opening, got %v", err)
+	}
+	defer reopened.Close()
+	if actual := string(FormatAgenda(reopened.Root)); actual != "* a\n* b\n" {
+		t.Errorf("Expected the saved agenda, got %q", actual)
+	}
+	if entries, err := ReadJournal(JournalPath(path)); err != nil || len(entries) != 1 {
+		t.Errorf("Expected a new journal, got %v, %v", entries, err)
+	}
+	if moved, _ := filepath.Glob(JournalPath(path) + ".*"); len(moved) != 1 {
+		t.Errorf("Expected the broken journal to be kept, got %v", moved)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gdamore/tcell"
+	"io"
+	"os"
+	"strings"
+)
+
+type ActionScope int
+
+const (
+	// Global actions run no matter which page is showing.
+	ScopeGlobal ActionScope = iota
+	// Main actions only run while the agenda tree is showing.
+	ScopeMain
+)
+
+type Action struct {
+	Name        string
+	Description string
+	Scope       ActionScope
+	// Repeatable actions run count times when prefixed with a count, eg. 5j.
+	Repeatable bool
+	Run        func()
+}
+
+type ActionRegistry struct {
+	Actions []*Action
+}
+
+// Maps key sequences to action names. A sequence is one or more chords, eg. "j",
+// "alt+j" or "ctrl+r", separated by spaces, eg. "g g". The chord "<leader>"
+// stands for whatever Leader is set to.
+type Keymap struct {
+	Sequences []string
+	Actions   map[string]string
+	Leader    string
+}
+
+func (registry *ActionRegistry) Register(action *Action) {
+	registry.Actions = append(registry.Actions, action)
+}
+
+func (registry *ActionRegistry) Find(name string) *Action {
+	for i := range registry.Actions {
+		if registry.Actions[i].Name == name {
+			return registry.Actions[i]
+		}
+	}
+	return nil
+}
+
+func NewKeymap() *Keymap {
+	return &Keymap{Actions: map[string]string{}, Leader: "\\"}
+}
+
+func DefaultKeymap() *Keymap {
+	keymap := NewKeymap()
+	for _, binding := range [][2]string{
+		{"?", "help"},
+		{"+", "add"},
+		{"ctrl+n", "add"},
+		{"ctrl+r", "redraw"},
+		{"ctrl+p", "palette"},
+		{"ctrl+s", "save"},
+		{"enter", "edit"},
+		{":", "command-line"},
+		{"k", "select-prev"},
+		{"j", "select-next"},
+		{"g g", "select-first"},
+		{"G", "select-last"},
+		{"alt+h", "outdent"},
+		{"alt+l", "indent"},
+		{"alt+k", "move-up"},
+		{"alt+j", "move-down"},
+		{"i", "clock-in"},
+		{"o", "clock-out"},
+		{"p", "cycle-priority"},
+		{"T", "cycle-todo"},
+		{"s", "sort-priority"},
+		{"S", "sort-title"},
+		{"d d", "delete"},
+		{"z c", "fold"},
+		{"z o", "unfold"},
+		{"z a", "toggle-fold"},
+		{"<leader> r", "clock-report"},
+		{"<leader> c", "column-view"},
+		{"<leader> m", "messages"},
+	} {
+		keymap.Bind(binding[0], binding[1])
+	}
+	return keymap
+}
+
+func (keymap *Keymap) Bind(sequence, action string) {
+	if _, ok := keymap.Actions[sequence]; !ok {
+		keymap.Sequences = append(keymap.Sequences, sequence)
+	}
+	keymap.Actions[sequence] = action
+}
+
+func (keymap *Keymap) Unbind(sequence string) {
+	if _, ok := keymap.Actions[sequence]; !ok {
+		return
+	}
+
+	delete(keymap.Actions, sequence)
+	for i := range keymap.Sequences {
+		if keymap.Sequences[i] == sequence {
+			keymap.Sequences = append(keymap.Sequences[:i], keymap.Sequences[i+1:]...)
+			break
+		}
+	}
+}
+
+// All sequences bound to action, in the order they were bound.
+func (keymap *Keymap) SequencesFor(action string) (sequences []string) {
+	for _, sequence := range keymap.Sequences {
+		if keymap.Actions[sequence] == action {
+			sequences = append(sequences, sequence)
+		}
+	}
+	return
+}
+
+// Reads bindings, one "<sequence> <action>" pair per line, on top of the
+// current ones. Binding a sequence to "none" removes it. "leader <chord>" sets
+// the leader. Blank lines and lines starting with # are ignored.
+func (keymap *Keymap) Load(r io.Reader, registry *ActionRegistry) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return fmt.Errorf("line %d: expected \"<sequence> <action>\"", line)
+		}
+
+		if fields[0] == "leader" && len(fields) == 2 {
+			leader, err := NormalizeChord(fields[1])
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			keymap.Leader = leader
+			continue
+		}
+
+		chords := fields[:len(fields)-1]
+		action := fields[len(fields)-1]
+		for i := range chords {
+			if chords[i] == "<leader>" {
+				continue
+			}
+			chord, err := NormalizeChord(chords[i])
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			chords[i] = chord
+		}
+		sequence := strings.Join(chords, " ")
+
+		if action == "none" {
+			keymap.Unbind(sequence)
+			continue
+		}
+
+		if registry.Find(action) == nil {
+			return fmt.Errorf("line %d: unknown action %q", line, action)
+		}
+		keymap.Bind(sequence, action)
+	}
+	return scanner.Err()
+}
+
+func (keymap *Keymap) LoadFile(path string, registry *ActionRegistry) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = keymap.Load(file, registry); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	return nil
+}
+
+// Handles event as the next key after pending. Runs the action bound to the
+// resulting sequence if it belongs to scope, or extends pending if the
+// sequence is the start of a longer binding or a count. Returns nil if event
+// was consumed, otherwise event.
+func (keymap *Keymap) Dispatch(event *tcell.EventKey, pending *PendingKeys, registry *ActionRegistry, scope ActionScope) *tcell.EventKey {
+	chord := ChordName(event)
+	sequence := strings.Join(append(append([]string{}, pending.Keys...), chord), " ")
+	isPrefix := false
+	hasRepeatable := false
+
+	for _, bound := range keymap.Sequences {
+		action := registry.Find(keymap.Actions[bound])
+		if action == nil || action.Scope != scope {
+			continue
+		}
+		hasRepeatable = hasRepeatable || action.Repeatable
+
+		expanded := keymap.expandLeader(bound)
+		if expanded == sequence {
+			count := 1
+			if action.Repeatable && pending.Count > 0 {
+				count = pending.Count
+			}
+			for i := 0; i < count; i++ {
+				action.Run()
+			}
+			return nil
+		}
+		if strings.HasPrefix(expanded, sequence+" ") {
+			isPrefix = true
+		}
+	}
+
+	if isPrefix {
+		pending.Extend(chord)
+		return nil
+	}
+
+	isDigit := event.Key() == tcell.KeyRune && event.Modifiers() == tcell.ModNone &&
+		event.Rune() >= '0' && event.Rune() <= '9'
+	if isDigit && hasRepeatable && len(pending.Keys) == 0 && (event.Rune() != '0' || pending.Count > 0) {
+		pending.AddDigit(int(event.Rune() - '0'))
+		return nil
+	}
+
+	return event
+}
+
+func (keymap *Keymap) expandLeader(sequence string) string {
+	chords := strings.Split(sequence, " ")
+	for i := range chords {
+		if chords[i] == "<leader>" {
+			chords[i] = keymap.Leader
+		}
+	}
+	return strings.Join(chords, " ")
+}
+
+// Help text listing every registered action with the sequences bound to it.
+// Actions without any bindings are left out.
+func (keymap *Keymap) HelpText(registry *ActionRegistry) string {
+	var lines []string
+	for _, action := range registry.Actions {
+		sequences := keymap.SequencesFor(action.Name)
+		if len(sequences) == 0 {
+			continue
+		}
+		for i := range sequences {
+			sequences[i] = keymap.expandLeader(sequences[i])
+		}
+		lines = append(lines, fmt.Sprintf("%-11v %v", strings.Join(sequences, ", "), action.Description))
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("%-11v %v", "<count>", "Repeat a movement, eg. 5j."),
+		fmt.Sprintf("%-11v %v", "ctrl+c", "Quit"),
+		fmt.Sprintf("%-11v %v", "esc", "Quit any popups, dialogs or modals."),
+	)
+	return strings.Join(lines, "\n")
+}
+
+// Canonical name of the chord for event: optional "ctrl+", "alt+" and "shift+"
+// prefixes followed by the rune itself or the lower-cased tcell key name.
+func ChordName(event *tcell.EventKey) string {
+	mods := event.Modifiers()
+	key := event.Key()
+	prefix := ""
+
+	if key == tcell.KeyRune {
+		if mods&tcell.ModAlt != 0 {
+			prefix = "alt+"
+		}
+		return prefix + string(event.Rune())
+	}
+
+	if key >= tcell.KeyCtrlA && key <= tcell.KeyCtrlZ && !isNamedControlKey(key) {
+		mods |= tcell.ModCtrl
+		key = tcell.KeyRune
+	}
+
+	if mods&tcell.ModCtrl != 0 {
+		prefix += "ctrl+"
+	}
+	if mods&tcell.ModAlt != 0 {
+		prefix += "alt+"
+	}
+	if mods&tcell.ModShift != 0 {
+		prefix += "shift+"
+	}
+
+	if key == tcell.KeyRune {
+		return prefix + string(rune('a'+event.Key()-tcell.KeyCtrlA))
+	}
+
+	if name, ok := tcell.KeyNames[key]; ok {
+		return prefix + strings.ToLower(name)
+	}
+	return prefix + fmt.Sprintf("key%d", key)
+}
+
+// Tab, Enter etc. share their codes with ctrl+i, ctrl+m etc. Terminals can't
+// tell them apart, so they're always named after the key.
+func isNamedControlKey(key tcell.Key) bool {
+	switch key {
+	case tcell.KeyTab, tcell.KeyEnter, tcell.KeyBackspace, tcell.KeyEscape:
+		return true
+	}
+	return false
+}
+
+// Canonicalizes a chord as written in a keymap file so it compares equal to
+// ChordName. Modifier and key names are case-insensitive, runes are not.
+func NormalizeChord(chord string) (string, error) {
+	parts := strings.Split(chord, "+")
+	key := parts[len(parts)-1]
+	if key == "" && len(parts) > 1 {
+		// The rune is "+" itself, eg. "alt++".
+		key = "+"
+		parts = parts[:len(parts)-1]
+	}
+
+	mods := map[string]bool{}
+	for _, mod := range parts[:len(parts)-1] {
+		mod = strings.ToLower(mod)
+		if mod != "ctrl" && mod != "alt" && mod != "shift" {
+			return "", fmt.Errorf("unknown modifier %q in %q", mod, chord)
+		}
+		mods[mod] = true
+	}
+
+	prefix := ""
+	for _, mod := range []string{"ctrl", "alt", "shift"} {
+		if mods[mod] {
+			prefix += mod + "+"
+		}
+	}
+
+	if len([]rune(key)) == 1 {
+		if mods["ctrl"] {
+			key = strings.ToLower(key)
+		}
+		return prefix + key, nil
+	}
+
+	key = strings.ToLower(key)
+	for _, name := range tcell.KeyNames {
+		if strings.ToLower(name) == key {
+			return prefix + key, nil
+		}
+	}
+	return "", fmt.Errorf("unknown key %q in %q", key, chord)
+}
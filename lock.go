@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How long a lock held from another host may go without being refreshed before
+// it is considered stale. Locks are refreshed every minute while held.
+var StaleLockAge = 10 * time.Minute
+
+// Lock is an advisory lock on an agenda file, so two instances of the app
+// don't overwrite each other's changes. It is a file next to the agenda
+// holding the host and PID of its owner.
+type Lock struct {
+	Path string
+	Host string
+	PID  int
+}
+
+// LockedError is returned by AcquireLock when another process holds the lock.
+type LockedError struct {
+	Lock
+}
+
+func (err *LockedError) Error() string {
+	return fmt.Sprintf("%v is locked by process %d on %v", err.Path, err.PID, err.Host)
+}
+
+// Where the lock for the agenda at path is kept.
+func LockPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock")
+}
+
+// Locks the agenda at path, replacing a stale or unreadable lock. Returns a
+// *LockedError if another process holds the lock.
+func AcquireLock(path string) (*Lock, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	lock := &Lock{Path: LockPath(path), Host: host, PID: os.Getpid()}
+
+	// The lock is written in full before it is linked into place, so others
+	// never read it half written and take it for stale.
+	temp, err := ioutil.TempFile(filepath.Dir(lock.Path), filepath.Base(lock.Path)+".")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(temp.Name())
+	err = temp.Chmod(0644)
+	if err == nil {
+		_, err = fmt.Fprintf(temp, "%v %v\n", lock.Host, lock.PID)
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := os.Link(temp.Name(), lock.Path)
+		if err == nil {
+			return lock, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		owner, err := ReadLock(lock.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil && !owner.IsStale() {
+			return nil, &LockedError{*owner}
+		}
+		if attempt > 0 {
+			return nil, fmt.Errorf("Couldn't replace stale lock %v", lock.Path)
+		}
+		log.Warn("Replacing stale lock %v", lock.Path)
+		if err := os.Remove(lock.Path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// Reads the owner of the lock at path.
+func ReadLock(path string) (*Lock, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("%v: expected \"host pid\"", path)
+	}
+	pid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return &Lock{Path: path, Host: fields[0], PID: pid}, nil
+}
+
+// Whether the owner of the lock is gone. On this host that is when its process
+// no longer exists. For other hosts it is when the lock hasn't been refreshed
+// for StaleLockAge.
+func (lock *Lock) IsStale() bool {
+	host, err := os.Hostname()
+	if err == nil && host == lock.Host {
+		process, err := os.FindProcess(lock.PID)
+		if err != nil {
+			return true
+		}
+		err = process.Signal(syscall.Signal(0))
+		return err != nil && err != syscall.EPERM
+	}
+
+	info, err := os.Stat(lock.Path)
+	return err == nil && time.Since(info.ModTime()) > StaleLockAge
+}
+
+// Marks the lock as still held, so other hosts don't take it for stale.
+func (lock *Lock) Refresh() error {
+	if lock == nil {
+		return nil
+	}
+	now := time.Now()
+	return os.Chtimes(lock.Path, now, now)
+}
+
+// Removes the lock, unless it was taken over in the meantime.
+func (lock *Lock) Release() error {
+	if lock == nil {
+		return nil
+	}
+	owner, err := ReadLock(lock.Path)
+	if err != nil || owner.Host != lock.Host || owner.PID != lock.PID {
+		return err
+	}
+	return os.Remove(lock.Path)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockOpensSecondInstanceReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agenda.txt")
+	if err := ioutil.WriteFile(path, []byte("* a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first := NewAgendaApp(NewNode("", ""))
+	if err := first.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if first.Files[0].ReadOnly {
+		t.Fatal("Expected the first instance to get the lock")
+	}
+
+	second := NewAgendaApp(NewNode("", ""))
+	if err := second.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if !second.Files[0].ReadOnly {
+		t.Fatal("Expected the second instance to open read-only")
+	}
+	second.Tree.Selected = headingTitled(second.Root, "a")
+	for _, action := range []string{"cycle-priority", "cycle-todo", "clock-in", "sort-title", "delete"} {
+		second.Actions.Find(action).Run()
+	}
+	second.CommandLine.Execute("tag +x")
+	if second.IsDirty() {
+		t.Errorf("Expected changes to the read-only file to be refused, got %q", FormatAgenda(second.Root))
+	}
+	second.Root.AddChild(NewNode("b", ""))
+	if err := second.Save(); err == nil {
+		t.Error("Expected saving read-only to fail")
+	}
+	second.Close()
+	if _, err := os.Stat(LockPath(path)); err != nil {
+		t.Errorf("Expected the read-only instance to leave the lock alone, got %v", err)
+	}
+
+	first.Close()
+	if _, err := os.Stat(LockPath(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock to be released, got %v", err)
+	}
+
+	// A lock left by a process that is gone is taken over.
+	host, _ := os.Hostname()
+	if err := ioutil.WriteFile(LockPath(path), []byte(fmt.Sprintf("%v %d\n", host, 1<<22+12345)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := AcquireLock(path)
+	if err != nil {
+		t.Fatalf("Expected to replace the stale lock, got %v", err)
+	}
+	lock.Release()
+}
+
+func TestLockIsTakenOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agenda.txt")
+
+	// Nobody finds the lock half written and takes it over.
+	for round := 0; round < 20; round++ {
+		locks := make(chan *Lock)
+		for i := 0; i < 8; i++ {
+			go func() {
+				lock, _ := AcquireLock(path)
+				locks <- lock
+			}()
+		}
+		var held []*Lock
+		for i := 0; i < 8; i++ {
+			if lock := <-locks; lock != nil {
+				held = append(held, lock)
+			}
+		}
+		if len(held) != 1 {
+			t.Fatalf("Expected the lock to be taken once, got %d", len(held))
+		}
+		if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+			t.Fatalf("Expected just the lock to be left, got %d files", len(files))
+		}
+		held[0].Release()
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rivo/tview"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (level LogLevel) String() string {
+	if level < LevelDebug || level > LevelError {
+		return fmt.Sprintf("level%d", level)
+	}
+	return logLevelNames[level]
+}
+
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i := range logLevelNames {
+		if strings.EqualFold(name, logLevelNames[i]) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown log level %q, expected debug, info, warn or error", name)
+}
+
+type LogEntry struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+}
+
+func (entry LogEntry) String() string {
+	return fmt.Sprintf("%v %-5v %v", entry.Time.Format("2006-01-02 15:04:05"), strings.ToUpper(entry.Level.String()), entry.Message)
+}
+
+type LogBackend interface {
+	Write(entry LogEntry)
+}
+
+// The last maxLogEntries entries are kept in memory for the messages page.
+const maxLogEntries = 1000
+
+// Logger keeps recent entries at or above Level in a ring buffer and passes
+// them on to its backends. Without backends nothing leaves the buffer, which
+// makes the zero configuration suitable for tests and headless use. Safe for
+// concurrent use.
+type Logger struct {
+	Level LogLevel
+
+	mutex    sync.Mutex
+	backends map[string]LogBackend
+	entries  []LogEntry
+	// Index of the oldest entry once the buffer is full.
+	next int
+}
+
+func NewLogger() *Logger {
+	return &Logger{Level: LevelInfo, backends: map[string]LogBackend{}}
+}
+
+// Sets the backend called name, replacing any previous one. A nil backend
+// removes it.
+func (logger *Logger) SetBackend(name string, backend LogBackend) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	if backend == nil {
+		delete(logger.backends, name)
+	} else {
+		logger.backends[name] = backend
+	}
+}
+
+func (logger *Logger) write(level LogLevel, format string, args ...interface{}) {
+	if level < logger.Level {
+		return
+	}
+	entry := LogEntry{Time: time.Now(), Level: level, Message: fmt.Sprintf(format, args...)}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	if len(logger.entries) < maxLogEntries {
+		logger.entries = append(logger.entries, entry)
+	} else {
+		logger.entries[logger.next] = entry
+		logger.next = (logger.next + 1) % maxLogEntries
+	}
+
+	names := make([]string, 0, len(logger.backends))
+	for name := range logger.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		logger.backends[name].Write(entry)
+	}
+}
+
+func (logger *Logger) Debug(format string, args ...interface{}) {
+	logger.write(LevelDebug, format, args...)
+}
+
+func (logger *Logger) Info(format string, args ...interface{}) {
+	logger.write(LevelInfo, format, args...)
+}
+
+func (logger *Logger) Warn(format string, args ...interface{}) {
+	logger.write(LevelWarn, format, args...)
+}
+
+func (logger *Logger) Error(format string, args ...interface{}) {
+	logger.write(LevelError, format, args...)
+}
+
+// The entries in the buffer, oldest first.
+func (logger *Logger) Entries() []LogEntry {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	entries := make([]LogEntry, 0, len(logger.entries))
+	entries = append(entries, logger.entries[logger.next:]...)
+	return append(entries, logger.entries[:logger.next]...)
+}
+
+// Writes entries to W, one per line, eg. to a log file.
+type WriterLogBackend struct {
+	W io.Writer
+}
+
+func (backend WriterLogBackend) Write(entry LogEntry) {
+	fmt.Fprintln(backend.W, entry)
+}
+
+func levelColor(level LogLevel) string {
+	switch level {
+	case LevelDebug:
+		return "gray"
+	case LevelWarn:
+		return "yellow"
+	case LevelError:
+		return "red"
+	}
+	return "-"
+}
+
+func formatLogEntry(entry LogEntry) string {
+	return fmt.Sprintf("[%v]%v %v[-]", levelColor(entry.Level), entry.Time.Format("15:04:05"), tview.Escape(entry.Message))
+}
+
+// Shows entries in a text view, like the log panel below the status bar.
+type TextViewLogBackend struct {
+	View  *tview.TextView
+	lines []string
+}
+
+func NewTextViewLogBackend(view *tview.TextView) *TextViewLogBackend {
+	view.SetDynamicColors(true)
+	return &TextViewLogBackend{View: view}
+}
+
+// Appends entry and scrolls to it. At least the last maxLogLines lines are
+// kept, and at most twice as many.
+func (backend *TextViewLogBackend) Write(entry LogEntry) {
+	line := formatLogEntry(entry)
+	backend.lines = append(backend.lines, line)
+
+	if len(backend.lines) > 2*maxLogLines {
+		backend.lines = backend.lines[len(backend.lines)-maxLogLines:]
+		backend.View.SetText(strings.Join(backend.lines, "\n"))
+	} else if len(backend.lines) > 1 {
+		fmt.Fprint(backend.View, "\n"+line)
+	} else {
+		fmt.Fprint(backend.View, line)
+	}
+	backend.View.ScrollToEnd()
+}
+
+const maxLogLines = 200
+
+// Every entry in logger's buffer, scrolled to the newest.
+func NewMessagesWidget(logger *Logger) (widget *Widget) {
+	view := tview.NewTextView()
+	view.SetBorder(true)
+	view.SetTitle("Messages")
+	view.SetDynamicColors(true)
+
+	var lines []string
+	for _, entry := range logger.Entries() {
+		lines = append(lines, formatLogEntry(entry))
+	}
+	view.SetText(strings.Join(lines, "\n"))
+	view.ScrollToEnd()
+
+	return &Widget{Name: "messages", Primitive: view}
+}
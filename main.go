@@ -3,17 +3,6 @@ package main
 /*
 
 TODO:
-- Bug: Can't add new child for existing item.
-  > Steps:
-    - Create item.
-    - Edit item.
-    - Create new item while editing.
-    - Return to main.
-  > Expected:
-    - New node is a child of edited node.
-  > Actual:
-    - New node is a child of parent node.
-
 - Feature: Render children somehow in the edit dialog. (Like a marker in the text.)
 - Feature: When adding children in the edit dialog, implicitly create continuation nodes around them.
 - Feature: Collapse continuations if children are moved. (Maybe not without undo?)
@@ -27,201 +16,220 @@ TODO:
 */
 
 import (
-	"github.com/gdamore/tcell"
-	"github.com/rivo/tview"
+	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 var (
-	log            DebugLog
-	boxShown       bool
+	log            = NewLogger()
 	rootAgendaNode *AgendaNode
 )
 
 func main() {
-	var editNode func(*AgendaNode, *AgendaNode)
+	reportFile := flag.String("clock-report", "", "Write a clock report as CSV to `file` (- for stdout) and exit.")
+	reportBy := flag.String("by", "node", "Group the clock report by node, tag, day or week.")
+	reportFrom := flag.String("from", "", "Start `date` (YYYY-MM-DD) of the clock report. Defaults to a week ago.")
+	reportTo := flag.String("to", "", "End `date` (YYYY-MM-DD) of the clock report, inclusive. Defaults to today.")
+	exportFormat := flag.String("export", "", "Write the agenda in `format` ("+strings.Join(ExportFormats(), ", ")+") to the -output file and exit.")
+	exportFile := flag.String("output", "-", "Write the export to `file` (- for stdout).")
+	keymapFile := flag.String("keymap", "", "Load key bindings from `file`, one \"<chord> <action>\" per line. Overrides the config.")
+	historyFile := flag.String("history", defaultHistoryFile(), "Keep command line history in `file`.")
+	logFile := flag.String("log-file", "", "Append log messages to `file`.")
+	logLevel := flag.String("log-level", "info", "Only log messages at or above `level`: debug, info, warn or error.")
+	themeName := flag.String("theme", "", "Color theme: dark, light, high-contrast or a theme `file`. Overrides the config, which defaults to dark.")
+	configFile := flag.String("config", DefaultConfigPath(), "Read settings from `file`.")
+	var agendaFlags listFlag
+	flag.Var(&agendaFlags, "agenda", "Open the agenda `file`, directory or glob instead of those in the config. May be repeated.")
+	flag.Parse()
+
+	level, err := ParseLogLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	log.Level = level
 
-	newNodeStack := Stack{}
-	boxShown = false
+	if *logFile != "" {
+		file, err := os.OpenFile(*logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		log.SetBackend("file", WriterLogBackend{file})
+	}
+
+	config := &Config{Extension: ".txt"}
+	if *configFile != "" {
+		if config, err = LoadConfig(*configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	// Files given on the command line replace those in the config. Without
+	// any the sample tree is shown and nothing is saved. Several files are
+	// opened as one workspace.
+	paths, err := config.AgendaFiles()
+	if len(agendaFlags) > 0 || flag.NArg() > 0 {
+		paths, err = ExpandAgendaPaths(append(agendaFlags, flag.Args()...), ".", config.Extension)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	rootAgendaNode := NewAgendaTree()
+	if len(paths) > 0 {
+		rootAgendaNode = NewNode("", "")
+	}
 
-	mainGrid := tview.NewGrid()
-
-	log.Primitive = tview.NewTextView()
-	log.Primitive.SetBorder(true)
-	log.Log("Program loaded")
-
-	tree := NewTree(rootAgendaNode)
-	tree.SetBorder(true)
-	tree.SetTitle("Agenda")
-	tree.SetSelectedFunc(func(node *AgendaNode) {
-		editNode(nil, node)
-	})
-
-	flex := tview.NewFlex()
-	flex.SetFullScreen(false)
-	flex.SetDirection(tview.FlexColumn)
-	flex.AddItem(tree, 0, 1, true)
-
-	help := tview.NewTextView()
-	help.SetBorder(true)
-	help.SetTitle("help")
-	help.SetText(strings.TrimSpace(helpText))
-
-	app := tview.NewApplication()
-	inputStack := InputHandlerStack{}
-	pageStack := PageStack{}
-
-	pages := tview.NewPages()
-	pages.AddPage("main", flex, true, true)
-	pages.AddPage("help", help, true, false)
-	pageStack.Push(&Page{Name: "main", Primitive: flex})
-
-	mainGrid.SetRows(-1, 3)
-	mainGrid.SetColumns(-1)
-	mainGrid.AddItem(pages, 0, 0, 1, 1, 1, 1, true)
-	mainGrid.AddItem(log.Primitive, 1, 0, 1, 1, 1, 1, false)
-
-	helpWidget := Widget{}
-	helpWidget.Primitive = help
-	helpWidget.InputHandler = createEscHandler(func() {
-		inputStack.Pop()
-		pageStack.Pop()
-		pages.SwitchToPage(pageStack.Top().Name)
-		log.Log("Exiting help, switching to %v", pageStack.Top().Name)
-		app.Draw()
-	})
-
-	box := tview.NewBox()
-	box.SetBorder(true)
-	box.SetTitle("A [red]c[yellow]o[green]l[darkcyan]o[blue]r[darkmagenta]f[red]u[yellow]l[white] [black:red]c[:yellow]o[:green]l[:darkcyan]o[:blue]r[:darkmagenta]f[:red]u[:yellow]l[white:] [::bu]title")
-
-	boxWidget := Widget{}
-	boxWidget.Primitive = box
-	boxWidget.InputHandler = createEscHandler(func() {
-		inputStack.Pop()
-		boxShown = false
-		log.Log("Exiting box, switching to main")
-		flex.RemoveItem(box)
-		app.Draw()
-	})
-
-	flexWidget := Widget{}
-	flexWidget.Primitive = flex
-	flexWidget.InputHandler = func(event *tcell.EventKey) (result *tcell.EventKey) {
-		result = event
-
-		if event.Key() == tcell.KeyRune && event.Rune() == 't' {
-			if !boxShown {
-				boxWidget.InputHandlerIndex = inputStack.Push(boxWidget.InputHandler)
-				flex.AddItem(box, 0, 1, true)
-				app.SetFocus(box)
-				boxShown = true
-				log.Log("Showing box")
-				result = nil
+	if *reportFile != "" || *exportFormat != "" {
+		log.SetBackend("stderr", WriterLogBackend{os.Stderr})
+		if len(paths) > 0 {
+			if rootAgendaNode, err = LoadAgendaFiles(paths...); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
 			}
+		}
+		if *reportFile != "" {
+			err = exportClockReport(rootAgendaNode, *reportFile, *reportBy, *reportFrom, *reportTo)
+		} else {
+			// The IDs are only kept for this export. Exporting from the app
+			// saves them, so UIDs stay the same across exports.
+			AssignIDs(rootAgendaNode)
+			err = Export(rootAgendaNode, *exportFormat, *exportFile)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-			app.Draw()
+	if *themeName == "" {
+		if *themeName, err = config.ThemeName(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+	}
+	theme, err := LoadTheme(*themeName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	theme.Apply()
 
-		return
+	app := NewAgendaApp(rootAgendaNode)
+	if len(paths) > 0 {
+		if err := app.Open(paths...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
 
-	editNode = func(scratch *AgendaNode, node *AgendaNode) {
-		editNodeWidget := NewEditAgendaNodeWidget(app, node, scratch)
-		editNodeWidget.InputHandler = createEscHandler(func() {
-			if newNodeStack.Count() <= 1 {
-				inputStack.Enable(flexWidget.InputHandlerIndex)
-			}
+	if *historyFile != "" {
+		if err := app.CommandLine.LoadHistory(*historyFile); err != nil {
+			log.Warn("Couldn't load history: %v", err)
+		}
+	}
 
-			if node.Parent == nil && node.NextContinuation == nil && node.PrevContinuation == nil {
-				if scratch != nil {
-					scratch.AddChild(node)
-				} else {
-					rootAgendaNode.AddChild(node)
-					tree.Selected = node
-				}
-			}
-			inputStack.Pop()
-			pageStack.Pop()
-			newNodeStack.Pop()
-			pages.SwitchToPage(pageStack.Top().Name)
-			log.Log("Exiting %v, switching to %v", editNodeWidget.Name, pageStack.Top().Name)
-			app.Draw()
-		})
-		editNodeWidget.InputHandlerIndex = inputStack.Push(editNodeWidget.InputHandler)
-		inputStack.Disable(flexWidget.InputHandlerIndex)
-		pageStack.Push(&Page{Name: editNodeWidget.Name, Primitive: editNodeWidget.Primitive})
-		pages.AddPage(editNodeWidget.Name, editNodeWidget.Primitive, true, true)
-		pages.SwitchToPage(editNodeWidget.Name)
-		log.Log("Showing %s", editNodeWidget.Name)
+	if *keymapFile == "" {
+		if *keymapFile, err = config.KeymapFile(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if *keymapFile != "" {
+		if err := app.LoadKeymap(*keymapFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if err := app.Run(); err != nil {
+		panic(err)
 	}
 
-	pagesWidget := Widget{}
-	pagesWidget.Primitive = pages
-	pagesWidget.InputHandler = func(event *tcell.EventKey) (result *tcell.EventKey) {
-		result = event
-
-		switch event.Key() {
-		case tcell.KeyCtrlR:
-			app.Draw()
-			result = nil
-
-		case tcell.KeyRune:
-			switch event.Rune() {
-			case '?':
-				if pageStack.Top().Name == "help" {
-					break
-				}
-
-				helpWidget.InputHandlerIndex = inputStack.Push(helpWidget.InputHandler)
-				pageStack.Push(&Page{Name: "help", Primitive: helpWidget.Primitive})
-				pages.SwitchToPage("help")
-				log.Log("Showing help")
-				result = nil
-
-			case '+':
-				var scratchNode *AgendaNode = nil
-				if newNodeStack.Top() != nil {
-					scratchNode = newNodeStack.Top().(*AgendaNode)
-				}
-				node := &AgendaNode{}
-				newNodeStack.Push(node)
-				editNode(scratchNode, node)
-				result = nil
+	if len(paths) == 0 {
+		rootAgendaNode.PrintTree(os.Stdout, 5)
+		return
+	}
+	failed := false
+	for _, file := range app.Files {
+		switch {
+		case !file.IsDirty():
+		case file.ReadOnly:
+			fmt.Fprintf(os.Stderr, "Discarding unsaved changes to %v, which was open read-only\n", file.Path)
+		default:
+			if err := file.Save(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed = true
 			}
 		}
-		app.Draw()
+	}
+	app.Close()
+	if failed {
+		os.Exit(1)
+	}
+}
 
-		return
+// listFlag collects the values of a flag given several times.
+type listFlag []string
+
+func (list *listFlag) String() string {
+	return strings.Join(*list, ", ")
+}
+
+func (list *listFlag) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
 	}
+	return filepath.Join(home, ".go-agenda_history")
+}
 
-	pagesWidget.InputHandlerIndex = inputStack.Push(pagesWidget.InputHandler)
-	flexWidget.InputHandlerIndex = inputStack.Push(flexWidget.InputHandler)
+func exportClockReport(root *AgendaNode, path, by, from, to string) error {
+	grouping, err := ParseClockReportGrouping(by)
+	if err != nil {
+		return err
+	}
 
-	app.SetFocus(pages)
-	app.SetInputCapture(createAppInputHandler(&inputStack))
-	if err := app.SetRoot(mainGrid, true).Run(); err != nil {
-		panic(err)
+	now := time.Now()
+	y, m, d := now.Date()
+	end := time.Date(y, m, d+1, 0, 0, 0, 0, time.Local)
+	start := end.AddDate(0, 0, -7)
+
+	if from != "" {
+		if start, err = time.ParseInLocation("2006-01-02", from, time.Local); err != nil {
+			return err
+		}
+	}
+	if to != "" {
+		if end, err = time.ParseInLocation("2006-01-02", to, time.Local); err != nil {
+			return err
+		}
+		end = end.AddDate(0, 0, 1)
 	}
 
-	rootAgendaNode.PrintTree(os.Stdout, 5)
-}
+	out := os.Stdout
+	if path != "-" {
+		if out, err = os.Create(path); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
 
-var helpText = `
-?           Show this help text.
-+           Add a new item.
-<ctrl+c>    Quit
-<esc>       Quit any popups, dialogs or modals.
-<enter>     Edit selected item.
-k           Select previous item in list.
-j           Select next item in list.
-<alt>+h     Outdent the item one level.
-<alt>+l     Indent the item one level.
-<alt>+k     Move an item up in the list. (Preserves nesting level.)
-<alt>+j     Move an item down in the list. (Preserves nesting level.)
-`
+	return WriteClockReportCSV(out, grouping, root.ClockReport(grouping, start, end, now))
+}
 
 func NewAgendaTree() *AgendaNode {
 	var p *AgendaNode
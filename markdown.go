@@ -0,0 +1,233 @@
+package main
+
+/*
+Markdown documents map onto the tree like agenda files do. ATX headings ("#",
+"##", ...) become headings, nested below the closest previous heading with
+fewer "#". Their priority and tags are written like in agenda files. Text up to
+the next heading is the heading's text, text before the first heading the
+root's. Lines inside fenced code blocks are always text.
+
+Markdown has no way to go back to a heading's text after one of its children,
+so continuations are marked with a comment naming the level of the heading
+they continue:
+
+# Heading 1
+text 1-1
+
+## Sub-Heading 1a
+text 1a-1
+
+<!-- continue # -->
+text 1-2
+
+TODO items without text or children of their own are written as a task list
+after their parent's text, "- [x]" for done and "- [ ]" for the others, as long
+as they come before the parent's other children. Task list items read back
+as children of the heading whose text they are in, and text after them
+starts a continuation of that heading. Other TODO items keep their keyword in the heading.
+
+Text lines that would be read as headings, continuations or task list items
+are escaped with "\". Properties and clocks aren't written. Headings nested deeper than six
+levels are written with more than six "#", which Markdown renderers show as
+text.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	markdownHeading  = regexp.MustCompile(`^(#+)(?:\s+(.*?))?(?:\s+#+)?\s*$`)
+	markdownContinue = regexp.MustCompile(`^<!-- continue (#+) -->$`)
+	markdownFence    = regexp.MustCompile("^\\s*(```|~~~)")
+	markdownTask     = regexp.MustCompile(`^[-*+] \[([ xX])\](?: (.*))?$`)
+)
+
+func ParseMarkdown(r io.Reader) (*AgendaNode, error) {
+	type openHeading struct {
+		level   int
+		node    *AgendaNode
+		segment *AgendaNode
+	}
+
+	root := NewNode("", "")
+	stack := []*openHeading{{node: root, segment: root}}
+	texts := map[*AgendaNode][]string{}
+	var fence string
+
+	addText := func(line string) {
+		top := stack[len(stack)-1]
+		// Text after task list items continues their parent. The root has no
+		// continuations, so its text is just joined up.
+		if line != "" && top.node != root && len(top.segment.Children) > 0 {
+			segment := NewNode("", "")
+			top.node.AddContinuation(segment)
+			top.segment = segment
+		}
+		texts[top.segment] = append(texts[top.segment], line)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		top := stack[len(stack)-1]
+
+		match := markdownFence.FindStringSubmatch(line)
+		if match != nil {
+			if fence == "" {
+				fence = match[1]
+			} else if fence == match[1] {
+				fence = ""
+			}
+		}
+		if fence != "" || match != nil {
+			addText(line)
+			continue
+		}
+
+		if match := markdownContinue.FindStringSubmatch(line); match != nil {
+			level := len(match[1])
+			for len(stack) > 1 && stack[len(stack)-1].level > level {
+				stack = stack[:len(stack)-1]
+			}
+			open := stack[len(stack)-1]
+			if open.level != level {
+				return nil, fmt.Errorf("No heading at level %d to continue", level)
+			}
+			segment := NewNode("", "")
+			open.node.AddContinuation(segment)
+			open.segment = segment
+			continue
+		}
+
+		if match := markdownHeading.FindStringSubmatch(line); match != nil {
+			level := len(match[1])
+			for len(stack) > 1 && stack[len(stack)-1].level >= level {
+				stack = stack[:len(stack)-1]
+			}
+			node := parseHeading(match[2])
+			stack[len(stack)-1].segment.AddChild(node)
+			stack = append(stack, &openHeading{level: level, node: node, segment: node})
+			continue
+		}
+
+		if match := markdownTask.FindStringSubmatch(line); match != nil {
+			node := parseHeading(match[2])
+			node.Todo = TodoKeywords[0]
+			if match[1] != " " {
+				node.Todo = TodoKeywords[len(TodoKeywords)-1]
+			}
+			top.segment.AddChild(node)
+			continue
+		}
+
+		if strings.HasPrefix(line, "\\") && needsMarkdownEscape(line[1:]) {
+			line = line[1:]
+		}
+		addText(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for segment, lines := range texts {
+		segment.Text = trimBlankLines(lines)
+	}
+	return root, nil
+}
+
+// Whether a text line would be read as a heading, continuation or task list
+// item. Lines escaped once stay escaped.
+func needsMarkdownEscape(line string) bool {
+	return strings.HasPrefix(line, "#") || markdownContinue.MatchString(line) || markdownTask.MatchString(line) ||
+		strings.HasPrefix(line, "\\") && needsMarkdownEscape(line[1:])
+}
+
+// Joins lines, leaving out blank ones at the start and end.
+func trimBlankLines(lines []string) string {
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func WriteMarkdown(w io.Writer, root *AgendaNode) error {
+	out := bufio.NewWriter(w)
+	// Blocks are separated by blank lines.
+	first := true
+	block := func(text string) {
+		if !first {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintln(out, text)
+		first = false
+	}
+	writeMarkdownText := func(text string) {
+		if text == "" {
+			return
+		}
+		lines := strings.Split(text, "\n")
+		var fence string
+		for i, line := range lines {
+			if match := markdownFence.FindStringSubmatch(line); match != nil {
+				if fence == "" {
+					fence = match[1]
+				} else if fence == match[1] {
+					fence = ""
+				}
+				continue
+			}
+			if fence == "" && needsMarkdownEscape(line) {
+				lines[i] = "\\" + line
+			}
+		}
+		block(strings.Join(lines, "\n"))
+	}
+
+	var writeHeading func(node *AgendaNode, level int)
+	writeChildren := func(children []*AgendaNode, level int) {
+		var tasks []string
+		for ; len(children) > 0 && isMarkdownTask(children[0]); children = children[1:] {
+			checkbox := "[ ]"
+			if children[0].IsDone() {
+				checkbox = "[x]"
+			}
+			task := *children[0]
+			task.Todo = ""
+			tasks = append(tasks, strings.TrimRight("- "+checkbox+" "+formatHeading(&task), " "))
+		}
+		if len(tasks) > 0 {
+			block(strings.Join(tasks, "\n"))
+		}
+		for _, child := range children {
+			writeHeading(child, level)
+		}
+	}
+	writeHeading = func(node *AgendaNode, level int) {
+		block(strings.TrimRight(strings.Repeat("#", level)+" "+formatHeading(node), " "))
+
+		for segment := node; segment != nil; segment = segment.NextContinuation {
+			if segment != node {
+				block(fmt.Sprintf("<!-- continue %v -->", strings.Repeat("#", level)))
+			}
+			writeMarkdownText(segment.Text)
+			writeChildren(segment.Children, level+1)
+		}
+	}
+
+	writeMarkdownText(root.Text)
+	writeChildren(root.Children, 1)
+	return out.Flush()
+}
+
+// Whether node is written as a task list item rather than a heading.
+func isMarkdownTask(node *AgendaNode) bool {
+	return node.Todo != "" && node.Text == "" && node.NextContinuation == nil && len(node.Children) == 0
+}
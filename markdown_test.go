@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	root := NewAgendaTree()
+	root.Text = "Preamble\n# not a heading"
+	rc1 := root.Children[0]
+	rc1.Priority = 'B'
+	rc1.Tags = []string{"work"}
+	rc1.Text = "first\n\n<!-- continue # -->\n\\# escaped\n```\n# in a fence\n```"
+	root.Children[1].AddChild(NewNode("", ""))
+	tasks := []*AgendaNode{
+		{Title: "open", Todo: "TODO"},
+		{Title: "TODO [#A] closed", Todo: "DONE", Tags: []string{"x"}},
+		{Title: "with text", Todo: "TODO", Text: "- [ ] not a task"},
+		{Title: "after a heading", Todo: "DONE"},
+	}
+	for _, task := range tasks {
+		rc1.AddChild(task)
+	}
+	root.InsertChild(&AgendaNode{Title: "top", Todo: "TODO"}, 0)
+
+	var buf bytes.Buffer
+	if err := WriteMarkdown(&buf, root); err != nil {
+		t.Fatal(err)
+	}
+	written := buf.String()
+	for _, line := range []string{"- [ ] open\n- [x] \\TODO [#A] closed :x:\n", "## TODO with text\n", "## DONE after a heading\n", "\n- [ ] top\n"} {
+		if !strings.Contains(written, line) {
+			t.Errorf("Expected %q in:\n%v", line, written)
+		}
+	}
+	parsed, err := ParseMarkdown(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, actual := FormatAgenda(root), FormatAgenda(parsed); !bytes.Equal(expected, actual) {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestParseMarkdown(t *testing.T) {
+	document := "Intro\n\n# One #\ntext\n\n### Deep\n\n## Two :a:b:\n\n```\n# code\n```\n\n# [#A] Three\n"
+	root, err := ParseMarkdown(strings.NewReader(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertOutline(t, root, "One", "  Deep", "  Two", "Three")
+	one, two, three := root.Children[0], root.Children[0].Children[1], root.Children[1]
+	if root.Text != "Intro" || one.Text != "text" || two.Text != "```\n# code\n```" {
+		t.Errorf("Unexpected texts %q, %q and %q", root.Text, one.Text, two.Text)
+	}
+	if strings.Join(two.Tags, ":") != "a:b" || three.Priority != 'A' {
+		t.Errorf("Expected tags and priority, got %v and %c", two.Tags, three.Priority)
+	}
+
+	root, err = ParseMarkdown(strings.NewReader("# List\nbefore\n- [ ] one\n- [X] two\nafter\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOutline(t, root, "List", "  one", "  two")
+	list := root.Children[0]
+	if list.Text != "before" || list.NextContinuation == nil || list.NextContinuation.Text != "after" {
+		t.Errorf("Expected text around the task list, got %q and %v", list.Text, list.NextContinuation)
+	}
+	if list.Children[0].Todo != "TODO" || list.Children[1].Todo != "DONE" {
+		t.Errorf("Expected TODO and DONE, got %q and %q", list.Children[0].Todo, list.Children[1].Todo)
+	}
+}
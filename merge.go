@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MergeAgendas merges the changes made from base to theirs into mine, heading
+// by heading, and returns the merged root. Headings are matched by title among
+// their siblings.
+//
+// A heading changed on one side only takes that side's version of its title,
+// priority, tags, properties, clocks and text. Children are merged the same
+// way, keeping the order of mine and placing headings added by theirs after
+// their previous sibling. Where both sides changed a heading differently mine
+// is kept and theirs is added after it without children, tagged "conflict". A
+// heading deleted on one side and changed on the other is kept. The titles of
+// both kinds of conflict are returned.
+//
+// The nodes of all three trees are reused.
+func MergeAgendas(base, mine, theirs *AgendaNode) (*AgendaNode, []string) {
+	m := &merger{}
+	root, conflict := m.heading(base, mine, theirs)
+	if conflict != nil {
+		// The root has no siblings to add theirs next to.
+		root.Text = strings.TrimSpace(root.Text + "\n\n" + conflict.Text)
+	}
+	return root, m.conflicts
+}
+
+type merger struct {
+	conflicts []string
+}
+
+// The parts of node merged as a whole: everything but its children.
+func ownContent(node *AgendaNode) string {
+	if node == nil {
+		return ""
+	}
+
+	parts := []string{node.Heading(), strings.Join(node.Tags, ":"), node.Properties.String()}
+	for _, interval := range node.Clocks {
+		parts = append(parts, fmt.Sprintf("%v--%v", interval.Start, interval.End))
+	}
+	for segment := node; segment != nil; segment = segment.NextContinuation {
+		parts = append(parts, segment.Text)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// node and everything below it, as written to a file.
+func subtreeContent(node *AgendaNode) string {
+	return string(FormatAgenda(&AgendaNode{Children: []*AgendaNode{node}}))
+}
+
+// The children of each segment of node.
+func segmentChildren(node *AgendaNode) (children [][]*AgendaNode) {
+	if node == nil {
+		return nil
+	}
+	for segment := node; segment != nil; segment = segment.NextContinuation {
+		children = append(children, segment.Children)
+	}
+	return
+}
+
+// Their version of a heading both sides changed, to be shown next to mine.
+func conflictCopy(theirs *AgendaNode) *AgendaNode {
+	var texts []string
+	for segment := theirs; segment != nil; segment = segment.NextContinuation {
+		if segment.Text != "" {
+			texts = append(texts, segment.Text)
+		}
+	}
+
+	node := NewNode(theirs.Title, strings.Join(texts, "\n\n"), theirs.Tags...)
+	node.AddTag("conflict")
+	node.Priority = theirs.Priority
+	node.Todo = theirs.Todo
+	node.Properties = theirs.Properties
+	return node
+}
+
+// Merges two versions of a heading, either of which may be unchanged from
+// base. base is nil when both sides added the heading. Returns the merged
+// heading and, if both changed it differently, a copy of theirs.
+func (m *merger) heading(base, mine, theirs *AgendaNode) (result, conflict *AgendaNode) {
+	baseChildren := segmentChildren(base)
+	mineChildren, theirChildren := segmentChildren(mine), segmentChildren(theirs)
+
+	result = mine
+	switch own := ownContent(mine); {
+	case base != nil && own == ownContent(base):
+		result = theirs
+	case own == ownContent(theirs) || (base != nil && ownContent(theirs) == ownContent(base)):
+	default:
+		m.conflicts = append(m.conflicts, mine.Title)
+		conflict = conflictCopy(theirs)
+	}
+
+	var segments []*AgendaNode
+	for segment := result; segment != nil; segment = segment.NextContinuation {
+		segment.Children = nil
+		segments = append(segments, segment)
+	}
+	count := len(segments)
+	for _, lists := range [][][]*AgendaNode{baseChildren, mineChildren, theirChildren} {
+		if len(lists) > count {
+			count = len(lists)
+		}
+	}
+	at := func(lists [][]*AgendaNode, i int) []*AgendaNode {
+		if i < len(lists) {
+			return lists[i]
+		}
+		return nil
+	}
+
+	// Children of segments the result lacks go to its last one.
+	for i := 0; i < count; i++ {
+		segment := segments[len(segments)-1]
+		if i < len(segments) {
+			segment = segments[i]
+		}
+		for _, child := range m.siblings(at(baseChildren, i), at(mineChildren, i), at(theirChildren, i)) {
+			segment.AddChild(child)
+		}
+	}
+	return
+}
+
+// Keys siblings by title, numbering repeated titles.
+func keyed(nodes []*AgendaNode) (keys []string, byKey map[string]*AgendaNode) {
+	byKey = map[string]*AgendaNode{}
+	seen := map[string]int{}
+	for _, node := range nodes {
+		key := fmt.Sprintf("%v\x00%d", node.Title, seen[node.Title])
+		seen[node.Title]++
+		keys = append(keys, key)
+		byKey[key] = node
+	}
+	return
+}
+
+func (m *merger) siblings(base, mine, theirs []*AgendaNode) (result []*AgendaNode) {
+	_, baseByKey := keyed(base)
+	mineKeys, mineByKey := keyed(mine)
+	theirKeys, theirsByKey := keyed(theirs)
+
+	// Whether a heading deleted on one side survives because the other side
+	// changed it.
+	keepDeleted := func(key string, node *AgendaNode) bool {
+		if subtreeContent(node) == subtreeContent(baseByKey[key]) {
+			return false
+		}
+		m.conflicts = append(m.conflicts, node.Title)
+		return true
+	}
+
+	// The merged node for each key placed so far.
+	placed := map[string]*AgendaNode{}
+	for _, key := range mineKeys {
+		node := mineByKey[key]
+		_, inBase := baseByKey[key]
+		switch {
+		case theirsByKey[key] != nil:
+			merged, conflict := m.heading(baseByKey[key], node, theirsByKey[key])
+			result = append(result, merged)
+			if conflict != nil {
+				result = append(result, conflict)
+			}
+			placed[key] = merged
+		case !inBase || keepDeleted(key, node):
+			result = append(result, node)
+			placed[key] = node
+		}
+	}
+
+	for i, key := range theirKeys {
+		node := theirsByKey[key]
+		_, inBase := baseByKey[key]
+		if mineByKey[key] != nil || (inBase && !keepDeleted(key, node)) {
+			continue
+		}
+
+		// After the nearest previous sibling in theirs that was placed.
+		index := 0
+		for j := i - 1; j >= 0; j-- {
+			if previous := placed[theirKeys[j]]; previous != nil {
+				index = indexOfNode(result, previous) + 1
+				break
+			}
+		}
+		result = append(result[:index], append([]*AgendaNode{node}, result[index:]...)...)
+		placed[key] = node
+	}
+	return
+}
+
+func indexOfNode(nodes []*AgendaNode, node *AgendaNode) int {
+	for i := range nodes {
+		if nodes[i] == node {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeAgendas(t *testing.T) {
+	parse := func(text string) *AgendaNode {
+		root, err := ParseAgenda(strings.NewReader(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+
+	base := parse("* a\n  text a\n* b\n  * b1\n* c\n* d\n")
+	mine := parse("* a\n  my text a\n* b\n  * b1\n  * b2\n* d\n  my d\n* e\n")
+	theirs := parse("* [#A] a\n  text a\n* b\n  * b0\n  * b1\n* c\n* x\n* d\n  their d\n")
+
+	merged, conflicts := MergeAgendas(base, mine, theirs)
+	expected := "* a\n  my text a\n* [#A] a :conflict:\n  text a\n* b\n  * b0\n  * b1\n  * b2\n* x\n* d\n  my d\n* d :conflict:\n  their d\n* e\n"
+	if actual := string(FormatAgenda(merged)); actual != expected {
+		t.Errorf("Expected\n%v\ngot\n%v", expected, actual)
+	}
+	if len(conflicts) != 2 || conflicts[0] != "a" || conflicts[1] != "d" {
+		t.Errorf("Expected conflicts on a and d, got %v", conflicts)
+	}
+}
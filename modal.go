@@ -0,0 +1,198 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"time"
+)
+
+// A Modal is one layer of the interface: the page it shows, the input handler
+// that gets keys while it is on top and what has focus meanwhile.
+type Modal struct {
+	*Widget
+	// Page modals are shown in place of the page below them. Others, like the
+	// command line, are put on screen by OnPush and taken off by OnPop.
+	IsPage bool
+	// Focus gets focus while the modal is on top. Defaults to Primitive.
+	Focus tview.Primitive
+	// Global key bindings are suspended while a modal taking text is on top.
+	TakesText bool
+	// Whatever the modal is working on, eg. the node being edited.
+	State  interface{}
+	OnPush func()
+	OnPop  func()
+}
+
+// What a ModalManager needs from the screen. Satisfied by TviewModalView, or
+// by a fake when there is no terminal.
+type ModalView interface {
+	AddPage(name string, primitive tview.Primitive)
+	RemovePage(name string)
+	SwitchToPage(name string)
+	SetFocus(primitive tview.Primitive)
+}
+
+// ModalManager keeps the stack of modals and routes keys to the one on top.
+// Keys it doesn't consume go to Global, unless the top modal takes text.
+type ModalManager struct {
+	Modals  []*Modal
+	View    ModalView
+	Global  InputHandler
+	Pending PendingKeys
+	// Pending keys are dropped when no key follows within Timeout.
+	Timeout time.Duration
+	// Called whenever a handler extends Pending.
+	OnPending func()
+}
+
+type TviewModalView struct {
+	App   *tview.Application
+	Pages *tview.Pages
+}
+
+func (view TviewModalView) AddPage(name string, primitive tview.Primitive) {
+	view.Pages.AddPage(name, primitive, true, false)
+}
+
+func (view TviewModalView) RemovePage(name string) {
+	view.Pages.RemovePage(name)
+}
+
+func (view TviewModalView) SwitchToPage(name string) {
+	view.Pages.SwitchToPage(name)
+}
+
+func (view TviewModalView) SetFocus(primitive tview.Primitive) {
+	view.App.SetFocus(primitive)
+}
+
+func (manager *ModalManager) Push(modal *Modal) {
+	manager.Modals = append(manager.Modals, modal)
+
+	if modal.IsPage {
+		manager.View.AddPage(modal.Name, modal.Primitive)
+		manager.View.SwitchToPage(modal.Name)
+	}
+	if modal.OnPush != nil {
+		modal.OnPush()
+	}
+	manager.focusTop()
+
+	log.Debug("Showing %v", modal.Name)
+}
+
+// Removes the top modal and hands page and focus back to the one below.
+// The bottom modal is never popped.
+func (manager *ModalManager) Pop() (modal *Modal) {
+	if len(manager.Modals) < 2 {
+		return nil
+	}
+
+	modal = manager.Top()
+	manager.Modals = manager.Modals[:len(manager.Modals)-1]
+	manager.Pending.Reset()
+
+	if modal.IsPage {
+		manager.View.RemovePage(modal.Name)
+		manager.View.SwitchToPage(manager.TopPage().Name)
+	}
+	if modal.OnPop != nil {
+		modal.OnPop()
+	}
+	manager.focusTop()
+
+	log.Debug("Exiting %v, switching to %v", modal.Name, manager.Top().Name)
+	return
+}
+
+func (manager *ModalManager) Top() *Modal {
+	if len(manager.Modals) < 1 {
+		return nil
+	}
+	return manager.Modals[len(manager.Modals)-1]
+}
+
+// The topmost modal that is a page, ie. the page currently showing.
+func (manager *ModalManager) TopPage() *Modal {
+	for i := len(manager.Modals) - 1; i >= 0; i-- {
+		if manager.Modals[i].IsPage {
+			return manager.Modals[i]
+		}
+	}
+	return nil
+}
+
+func (manager *ModalManager) IndexName(name string) int {
+	for i := range manager.Modals {
+		if manager.Modals[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (manager *ModalManager) focusTop() {
+	top := manager.Top()
+	if top == nil {
+		return
+	}
+
+	focus := top.Focus
+	if focus == nil {
+		focus = top.Primitive
+	}
+	if focus != nil {
+		manager.View.SetFocus(focus)
+	}
+}
+
+// Drops pending keys that have been waiting longer than Timeout. Returns true
+// if anything was dropped.
+func (manager *ModalManager) ExpirePending(now time.Time) bool {
+	if manager.Pending.IsEmpty() || manager.Timeout <= 0 || now.Sub(manager.Pending.Since) < manager.Timeout {
+		return false
+	}
+	manager.Pending.Reset()
+	return true
+}
+
+// Offers event to the top modal, then to Global. Suitable for
+// tview.Application.SetInputCapture.
+func (manager *ModalManager) HandleKey(event *tcell.EventKey) *tcell.EventKey {
+	manager.ExpirePending(time.Now())
+	wasPending := !manager.Pending.IsEmpty()
+	manager.Pending.extended = false
+
+	var handlers []InputHandler
+	if top := manager.Top(); top != nil {
+		if top.InputHandler != nil {
+			handlers = append(handlers, top.InputHandler)
+		}
+		if !top.TakesText && manager.Global != nil {
+			handlers = append(handlers, manager.Global)
+		}
+	}
+
+	for _, handler := range handlers {
+		if handler(event) != nil {
+			continue
+		}
+
+		if manager.Pending.extended {
+			manager.Pending.Since = time.Now()
+			if manager.OnPending != nil {
+				manager.OnPending()
+			}
+		} else {
+			manager.Pending.Reset()
+		}
+		return nil
+	}
+
+	// A key that doesn't continue a sequence cancels it, like in vim.
+	manager.Pending.Reset()
+	if wasPending {
+		return nil
+	}
+	return event
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Records what a ModalManager does to the screen.
+type fakeModalView struct {
+	calls []string
+	focus tview.Primitive
+}
+
+func (view *fakeModalView) AddPage(name string, _ tview.Primitive) {
+	view.calls = append(view.calls, "add "+name)
+}
+
+func (view *fakeModalView) RemovePage(name string) {
+	view.calls = append(view.calls, "remove "+name)
+}
+
+func (view *fakeModalView) SwitchToPage(name string) {
+	view.calls = append(view.calls, "switch "+name)
+}
+
+func (view *fakeModalView) SetFocus(primitive tview.Primitive) {
+	view.focus = primitive
+}
+
+func (view *fakeModalView) assertCalls(t *testing.T, expected ...string) {
+	t.Helper()
+	if strings.Join(view.calls, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("Expected %v, got %v", expected, view.calls)
+	}
+	view.calls = nil
+}
+
+func TestModalManagerPushPop(t *testing.T) {
+	view := &fakeModalView{}
+	manager := &ModalManager{View: view}
+	page, edit, field := tview.NewBox(), tview.NewBox(), tview.NewInputField()
+
+	if manager.Top() != nil || manager.Pop() != nil {
+		t.Fatal("Expected no modals")
+	}
+	manager.Push(&Modal{Widget: &Widget{Name: "main", Primitive: page}, IsPage: true})
+	view.assertCalls(t, "add main", "switch main")
+
+	manager.Push(&Modal{Widget: &Widget{Name: "edit", Primitive: edit}, IsPage: true, Focus: field,
+		OnPush: func() { view.calls = append(view.calls, "push edit") },
+		OnPop:  func() { view.calls = append(view.calls, "pop edit") },
+	})
+	view.assertCalls(t, "add edit", "switch edit", "push edit")
+	if manager.Top().Name != "edit" || view.focus != field {
+		t.Errorf("Expected edit on top with its field focused, got %v", manager.Top().Name)
+	}
+
+	manager.Push(&Modal{Widget: &Widget{Name: "command-line", Primitive: tview.NewInputField()},
+		OnPush: func() { view.calls = append(view.calls, "push command-line") },
+		OnPop:  func() { view.calls = append(view.calls, "pop command-line") },
+	})
+	view.assertCalls(t, "push command-line")
+	if manager.TopPage().Name != "edit" {
+		t.Errorf("Expected edit to stay the page, got %v", manager.TopPage().Name)
+	}
+
+	if popped := manager.Pop(); popped.Name != "command-line" || view.focus != field {
+		t.Errorf("Expected to pop the command line back to the field, got %v", popped.Name)
+	}
+	view.assertCalls(t, "pop command-line")
+
+	// The page below is showing again before OnPop runs.
+	manager.Pop()
+	view.assertCalls(t, "remove edit", "switch main", "pop edit")
+	if manager.Top().Name != "main" || view.focus != page {
+		t.Errorf("Expected main on top and focused, got %v", manager.Top().Name)
+	}
+
+	if manager.Pop() != nil || manager.Top().Name != "main" {
+		t.Error("Expected the bottom modal to stay")
+	}
+	view.assertCalls(t)
+}
+
+func TestModalManagerHandleKey(t *testing.T) {
+	var handled []string
+	handler := func(name string, consumes ...rune) InputHandler {
+		return func(event *tcell.EventKey) *tcell.EventKey {
+			for _, r := range consumes {
+				if event.Rune() == r {
+					handled = append(handled, name+" "+string(r))
+					return nil
+				}
+			}
+			return event
+		}
+	}
+	key := func(r rune) *tcell.EventKey {
+		return tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone)
+	}
+
+	manager := &ModalManager{View: &fakeModalView{}, Global: handler("global", 'a', 'q')}
+	manager.Push(&Modal{Widget: &Widget{Name: "main", InputHandler: handler("main", 'a', 'b')}, IsPage: true})
+	for _, r := range "abc" {
+		manager.HandleKey(key(r))
+	}
+	manager.Push(&Modal{Widget: &Widget{Name: "text", InputHandler: handler("text")}, TakesText: true})
+	if manager.HandleKey(key('q')) == nil {
+		t.Error("Expected a modal taking text to get keys bound globally")
+	}
+	manager.Pop()
+	manager.HandleKey(key('q'))
+	if strings.Join(handled, ", ") != "main a, main b, global q" {
+		t.Errorf("Expected the top modal before global bindings, got %v", handled)
+	}
+
+	// A handler extending Pending waits for the next key.
+	pendings := 0
+	manager.OnPending = func() { pendings++ }
+	manager.Top().InputHandler = func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Rune() == 'g' {
+			manager.Pending.Extend("g")
+			return nil
+		}
+		return event
+	}
+	manager.HandleKey(key('g'))
+	if manager.Pending.String() == "" || pendings != 1 {
+		t.Fatalf("Expected a pending g, got %q after %d calls", manager.Pending.String(), pendings)
+	}
+	if manager.HandleKey(key('x')) != nil || !manager.Pending.IsEmpty() {
+		t.Error("Expected a key not continuing the sequence to cancel it and be swallowed")
+	}
+
+	manager.Timeout = time.Second
+	manager.HandleKey(key('g'))
+	if manager.ExpirePending(time.Now()) || !manager.ExpirePending(time.Now().Add(2*time.Second)) || !manager.Pending.IsEmpty() {
+		t.Error("Expected the pending g to expire after Timeout")
+	}
+
+	manager.HandleKey(key('g'))
+	manager.Push(&Modal{Widget: &Widget{Name: "help"}})
+	manager.Pop()
+	if !manager.Pending.IsEmpty() {
+		t.Error("Expected popping a modal to drop pending keys")
+	}
+}
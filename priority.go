@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Priorities from highest to lowest. Nodes without a priority sort after all of these.
+var Priorities = []rune{'A', 'B', 'C'}
+
+type SortKey int
+
+const (
+	SortByPriority SortKey = iota
+	SortByTitle
+	SortByTodo
+	// By the DEADLINE property. Nodes without a valid one sort last.
+	SortByDeadline
+)
+
+// Names of the sort keys, as given to the sort command.
+var sortKeyNames = []string{"priority", "title", "todo", "deadline"}
+
+func (key SortKey) String() string {
+	return sortKeyNames[key]
+}
+
+func ParseSortKey(name string) (SortKey, error) {
+	for i := range sortKeyNames {
+		if sortKeyNames[i] == name {
+			return SortKey(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Can't sort by %q", name)
+}
+
+// Cycles through no priority, then each of Priorities in turn.
+func (node *AgendaNode) CyclePriority() {
+	rank := priorityRank(node.Priority)
+	if rank+1 >= len(Priorities) {
+		node.Priority = 0
+	} else {
+		node.Priority = Priorities[rank+1]
+	}
+	node.Touch()
+}
+
+// Stable sort of parent's children. Continuations of parent are left alone.
+func (parent *AgendaNode) SortChildren(key SortKey) {
+	var less func(a, b *AgendaNode) bool
+
+	switch key {
+	case SortByPriority:
+		rank := func(node *AgendaNode) int {
+			if node.Priority == 0 {
+				return len(Priorities)
+			}
+			return priorityRank(node.Priority)
+		}
+		less = func(a, b *AgendaNode) bool {
+			return rank(a) < rank(b)
+		}
+	case SortByTitle:
+		less = func(a, b *AgendaNode) bool {
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		}
+	case SortByTodo:
+		rank := func(node *AgendaNode) int {
+			if node.Todo == "" {
+				return len(TodoKeywords)
+			}
+			return todoRank(node.Todo)
+		}
+		less = func(a, b *AgendaNode) bool {
+			return rank(a) < rank(b)
+		}
+	case SortByDeadline:
+		deadline := func(node *AgendaNode) (time.Time, bool) {
+			value, ok := node.Properties.Get("DEADLINE")
+			if !ok {
+				return time.Time{}, false
+			}
+			stamp, err := ParseTimestamp(value)
+			return stamp.Time, err == nil
+		}
+		less = func(a, b *AgendaNode) bool {
+			aTime, aOk := deadline(a)
+			bTime, bOk := deadline(b)
+			if aOk != bOk {
+				return aOk
+			}
+			return aOk && aTime.Before(bTime)
+		}
+	}
+
+	sort.SliceStable(parent.Children, func(i, j int) bool {
+		return less(parent.Children[i], parent.Children[j])
+	})
+	parent.Touch()
+}
+
+// Returns the index of priority in Priorities, or -1 for no priority.
+func priorityRank(priority rune) int {
+	for i := range Priorities {
+		if Priorities[i] == priority {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortChildren(t *testing.T) {
+	root, err := ParseAgenda(strings.NewReader(`* none
+* DONE [#A] done
+  :PROPERTIES:
+  :DEADLINE: 2020-09-30
+  :END:
+* TODO soon
+  :PROPERTIES:
+  :DEADLINE: <2020-09-14 Mon 10:00>
+  :END:
+* [#B] broken
+  :PROPERTIES:
+  :DEADLINE: next week
+  :END:
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root.SortChildren(SortByTodo)
+	assertOutline(t, root, "soon", "done", "none", "broken")
+	root.SortChildren(SortByDeadline)
+	assertOutline(t, root, "soon", "done", "none", "broken")
+	root.SortChildren(SortByPriority)
+	assertOutline(t, root, "done", "broken", "soon", "none")
+	root.SortChildren(SortByTitle)
+	assertOutline(t, root, "broken", "done", "none", "soon")
+	root.SortChildren(SortByDeadline)
+	assertOutline(t, root, "soon", "done", "broken", "none")
+
+	for _, name := range sortKeyNames {
+		if key, err := ParseSortKey(name); err != nil || key.String() != name {
+			t.Errorf("Expected %q to parse back, got %v, %v", name, key, err)
+		}
+	}
+}
+
+func TestCycleTodo(t *testing.T) {
+	node := NewNode("a", "")
+	for _, expected := range []string{"TODO", "DONE", "", "TODO"} {
+		node.CycleTodo()
+		if node.Todo != expected {
+			t.Errorf("Expected %q, got %q", expected, node.Todo)
+		}
+	}
+	if node.Heading() != "TODO a" || node.IsDone() {
+		t.Errorf("Expected an open TODO, got %q", node.Heading())
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Properties is an ordered set of key/value metadata attached to an AgendaNode.
+// Keys are case-insensitive and stored upper-cased, eg. EFFORT, OWNER.
+// Values are kept as text and interpreted on demand with ParseNumber and
+// ParseDuration.
+type Properties struct {
+	Keys   []string
+	Values map[string]string
+}
+
+func (props *Properties) Get(key string) (value string, ok bool) {
+	value, ok = props.Values[strings.ToUpper(key)]
+	return
+}
+
+func (props *Properties) Set(key, value string) {
+	key = strings.ToUpper(key)
+	if props.Values == nil {
+		props.Values = map[string]string{}
+	}
+	if _, ok := props.Values[key]; !ok {
+		props.Keys = append(props.Keys, key)
+	}
+	props.Values[key] = value
+}
+
+func (props *Properties) Delete(key string) {
+	key = strings.ToUpper(key)
+	if _, ok := props.Values[key]; !ok {
+		return
+	}
+
+	delete(props.Values, key)
+	for i := range props.Keys {
+		if props.Keys[i] == key {
+			props.Keys = append(props.Keys[:i], props.Keys[i+1:]...)
+			break
+		}
+	}
+}
+
+func (props *Properties) Len() int {
+	return len(props.Keys)
+}
+
+// Formats as "KEY=value; KEY=value", the form accepted by ParseProperties.
+// Semicolons and backslashes in values are escaped with a backslash.
+func (props *Properties) String() string {
+	pairs := make([]string, len(props.Keys))
+	for i, key := range props.Keys {
+		pairs[i] = fmt.Sprintf("%v=%v", key, propertyEscaper.Replace(props.Values[key]))
+	}
+	return strings.Join(pairs, "; ")
+}
+
+var propertyEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`)
+
+// Keys may not contain whitespace or ":", which agenda files can't hold.
+var propertyKey = regexp.MustCompile(`^[^\s:]+$`)
+
+func ValidPropertyKey(key string) bool {
+	return propertyKey.MatchString(key)
+}
+
+func ParseProperties(text string) (props Properties, err error) {
+	for _, pair := range splitProperties(text) {
+		kv := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if key == "" {
+			continue
+		}
+		if !ValidPropertyKey(key) {
+			return Properties{}, fmt.Errorf("Invalid property name %q", key)
+		}
+
+		value := ""
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+		props.Set(key, value)
+	}
+	return
+}
+
+// Splits text at semicolons not escaped by a backslash, and drops the escapes.
+func splitProperties(text string) (pairs []string) {
+	var pair strings.Builder
+	escaped := false
+	for _, r := range text {
+		switch {
+		case escaped:
+			pair.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ';':
+			pairs = append(pairs, pair.String())
+			pair.Reset()
+		default:
+			pair.WriteRune(r)
+		}
+	}
+	return append(pairs, pair.String())
+}
+
+func ParseNumber(value string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(value), 64)
+}
+
+// Accepts "h:mm" as used for efforts and clocked time, or anything
+// time.ParseDuration understands.
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return time.ParseDuration(value)
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("Invalid duration %q", value)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("Invalid duration %q", value)
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// Returns the first node of the chain of continuations node belongs to, ie.,
+// the one carrying the title, tags and properties.
+func (node *AgendaNode) Head() *AgendaNode {
+	for ; node.PrevContinuation != nil; node = node.PrevContinuation {
+	}
+	return node
+}
+
+// Looks up a property on node. When inherit is set and node doesn't have the
+// property, its ancestors are searched from nearest to furthest.
+func (node *AgendaNode) Property(key string, inherit bool) (string, bool) {
+	for node = node.Head(); node != nil; {
+		if value, ok := node.Properties.Get(key); ok {
+			return value, true
+		}
+		if !inherit || node.Parent == nil {
+			break
+		}
+		node = node.Parent.Head()
+	}
+	return "", false
+}
+
+// Finds every node whose property key equals value. An empty value matches any
+// node that has the property at all.
+func (root *AgendaNode) FindByProperty(key, value string, inherit bool) (found []*AgendaNode) {
+	root.Walk(func(node *AgendaNode, _ int) {
+		if node.IsContinuation() {
+			return
+		}
+		actual, ok := node.Property(key, inherit)
+		if ok && (value == "" || strings.EqualFold(actual, value)) {
+			found = append(found, node)
+		}
+	})
+	return
+}
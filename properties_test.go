@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPropertiesStringRoundTrip(t *testing.T) {
+	var props Properties
+	props.Set("url", "http://example.com/a;b")
+	props.Set("path", `C:\notes\`)
+	props.Set("effort", "1:00")
+
+	text := props.String()
+	if expected := `URL=http://example.com/a\;b; PATH=C:\\notes\\; EFFORT=1:00`; text != expected {
+		t.Errorf("Expected %v, got %v", expected, text)
+	}
+	parsed, err := ParseProperties(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != text || parsed.Len() != 3 {
+		t.Errorf("Expected %v to parse back, got %v", text, parsed.String())
+	}
+	if value, _ := parsed.Get("URL"); value != "http://example.com/a;b" {
+		t.Errorf("Expected the semicolon to be kept, got %q", value)
+	}
+
+	if parsed, err := ParseProperties(" a = 1 ;; b"); err != nil || parsed.String() != "A=1; B=" {
+		t.Errorf("Expected A and B, got %v, %v", parsed.String(), err)
+	}
+	for _, text := range []string{"MY KEY=1", "A:B=1"} {
+		if _, err := ParseProperties(text); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+}
+
+func TestFindCommand(t *testing.T) {
+	root, err := ParseAgenda(strings.NewReader(`* a
+  :PROPERTIES:
+  :OWNER: ann
+  :END:
+* b
+  * b1
+    :PROPERTIES:
+    :OWNER: Ann
+    :END:
+* c
+  :PROPERTIES:
+  :OWNER: bob
+  :END:
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.Children[1].Folded = true
+	app := NewAgendaApp(root)
+	app.Tree.Selected = root.Children[1]
+
+	for _, expected := range []string{"b1", "a", "b1"} {
+		if err := app.CommandLine.Execute("find owner=ann"); err != nil {
+			t.Fatal(err)
+		}
+		if app.Tree.Selected.Title != expected {
+			t.Errorf("Expected %v to be selected, got %v", expected, app.Tree.Selected.Title)
+		}
+	}
+	if root.Children[1].Folded {
+		t.Error("Expected the match to be unfolded")
+	}
+	if err := app.CommandLine.Execute("find owner"); err != nil || app.Tree.Selected.Title != "c" {
+		t.Errorf("Expected any OWNER to match, got %v, %v", app.Tree.Selected.Title, err)
+	}
+	if err := app.CommandLine.Execute("find due"); err == nil {
+		t.Error("Expected no match")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rivo/tview"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StatusBar is the line below the pages. The left shows the mode, the file of
+// the selection, whether it has unsaved changes or is read-only and where the
+// selection is in the outline, the right the running clock and pending keys.
+type StatusBar struct {
+	*tview.Flex
+	left  *tview.TextView
+	right *tview.TextView
+}
+
+func NewStatusBar() *StatusBar {
+	bar := &StatusBar{
+		Flex:  tview.NewFlex(),
+		left:  tview.NewTextView(),
+		right: tview.NewTextView(),
+	}
+	bar.left.SetDynamicColors(true)
+	bar.right.SetDynamicColors(true)
+	bar.right.SetTextAlign(tview.AlignRight)
+	bar.AddItem(bar.left, 0, 1, false)
+	bar.AddItem(bar.right, 0, 1, false)
+	return bar
+}
+
+func (bar *StatusBar) Update(app *AgendaApp, now time.Time) {
+	mode := strings.TrimRight(app.Modals.Top().Name, "0123456789")
+	left := fmt.Sprintf("[::r] %v [::-]", tview.Escape(mode))
+	file := app.FileOf(app.Tree.Selected)
+	if file == nil && len(app.Files) > 0 {
+		file = app.Files[0]
+	}
+	if file != nil {
+		left += " " + tview.Escape(filepath.Base(file.Path))
+		if file.IsDirty() {
+			left += " [+]"
+		}
+		if file.ReadOnly {
+			left += " " + tview.Escape("[RO]")
+		}
+	}
+	if app.Tree.Selected != nil {
+		left += " " + tview.Escape(OutlinePath(app.Tree.Selected))
+	}
+	bar.left.SetText(left)
+
+	var right []string
+	if status := app.Tree.Clock.Status(now); status != "" {
+		right = append(right, tview.Escape(status))
+	}
+	if !app.Modals.Pending.IsEmpty() {
+		right = append(right, tview.Escape(fmt.Sprintf("[%v]", app.Modals.Pending.String())))
+	}
+	bar.right.SetText(strings.Join(right, "    "))
+}
+
+// Titles of node and its ancestors, outermost first, eg. "Work > Project > Task".
+func OutlinePath(node *AgendaNode) string {
+	var titles []string
+	for ; node != nil && node.Head().Parent != nil; node = node.Head().Parent {
+		titles = append([]string{node.Head().Title}, titles...)
+	}
+	return strings.Join(titles, " > ")
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"io"
+	"os"
+	"strings"
+)
+
+// Theme holds the colors of the interface. Widgets pick colors up when they
+// are created, so a theme must be applied before the app is built.
+type Theme struct {
+	Name string
+
+	// Colors of all widgets, see tview.Styles.
+	Background tcell.Color
+	Contrast   tcell.Color
+	Border     tcell.Color
+	Title      tcell.Color
+	Text       tcell.Color
+	Secondary  tcell.Color
+
+	// Colors of the tree.
+	Heading       tcell.Color
+	Body          tcell.Color
+	Selection     tcell.Color
+	SelectionText tcell.Color
+	Priorities    map[rune]tcell.Color
+	Todo          tcell.Color
+	Done          tcell.Color
+	// Deadlines that have passed or are coming up soon.
+	DeadlineOverdue tcell.Color
+	DeadlineSoon    tcell.Color
+	Tag             tcell.Color
+	// Colors of individual tags, overriding Tag.
+	Tags map[string]tcell.Color
+
+	Log tcell.Color
+}
+
+// The theme used by widgets created from now on.
+var CurrentTheme = DarkTheme()
+
+// Matches tview's default styles.
+func DarkTheme() *Theme {
+	return &Theme{
+		Name:            "dark",
+		Background:      tcell.ColorBlack,
+		Contrast:        tcell.ColorBlue,
+		Border:          tcell.ColorWhite,
+		Title:           tcell.ColorWhite,
+		Text:            tcell.ColorWhite,
+		Secondary:       tcell.ColorYellow,
+		Heading:         tcell.ColorWhite,
+		Body:            tcell.ColorGreen,
+		Selection:       tcell.ColorWhite,
+		SelectionText:   tcell.ColorBlack,
+		Priorities:      map[rune]tcell.Color{'A': tcell.ColorRed, 'B': tcell.ColorYellow, 'C': tcell.ColorGreen},
+		Todo:            tcell.ColorRed,
+		Done:            tcell.ColorGreen,
+		DeadlineOverdue: tcell.ColorRed,
+		DeadlineSoon:    tcell.ColorYellow,
+		Tag:             tcell.ColorDarkCyan,
+		Tags:            map[string]tcell.Color{},
+		Log:             tcell.ColorWhite,
+	}
+}
+
+func LightTheme() *Theme {
+	return &Theme{
+		Name:            "light",
+		Background:      tcell.ColorWhite,
+		Contrast:        tcell.ColorLightBlue,
+		Border:          tcell.ColorBlack,
+		Title:           tcell.ColorBlack,
+		Text:            tcell.ColorBlack,
+		Secondary:       tcell.ColorNavy,
+		Heading:         tcell.ColorBlack,
+		Body:            tcell.ColorDarkGreen,
+		Selection:       tcell.ColorNavy,
+		SelectionText:   tcell.ColorWhite,
+		Priorities:      map[rune]tcell.Color{'A': tcell.ColorMaroon, 'B': tcell.ColorOlive, 'C': tcell.ColorDarkGreen},
+		Todo:            tcell.ColorMaroon,
+		Done:            tcell.ColorDarkGreen,
+		DeadlineOverdue: tcell.ColorMaroon,
+		DeadlineSoon:    tcell.ColorOlive,
+		Tag:             tcell.ColorTeal,
+		Tags:            map[string]tcell.Color{},
+		Log:             tcell.ColorDimGray,
+	}
+}
+
+func HighContrastTheme() *Theme {
+	return &Theme{
+		Name:            "high-contrast",
+		Background:      tcell.ColorBlack,
+		Contrast:        tcell.ColorWhite,
+		Border:          tcell.ColorWhite,
+		Title:           tcell.ColorYellow,
+		Text:            tcell.ColorWhite,
+		Secondary:       tcell.ColorYellow,
+		Heading:         tcell.ColorWhite,
+		Body:            tcell.ColorWhite,
+		Selection:       tcell.ColorYellow,
+		SelectionText:   tcell.ColorBlack,
+		Priorities:      map[rune]tcell.Color{'A': tcell.ColorRed, 'B': tcell.ColorYellow, 'C': tcell.ColorAqua},
+		Todo:            tcell.ColorRed,
+		Done:            tcell.ColorAqua,
+		DeadlineOverdue: tcell.ColorRed,
+		DeadlineSoon:    tcell.ColorYellow,
+		Tag:             tcell.ColorAqua,
+		Tags:            map[string]tcell.Color{},
+		Log:             tcell.ColorWhite,
+	}
+}
+
+func BuiltinTheme(name string) *Theme {
+	switch name {
+	case "dark":
+		return DarkTheme()
+	case "light":
+		return LightTheme()
+	case "high-contrast":
+		return HighContrastTheme()
+	}
+	return nil
+}
+
+// Loads the built in theme called name, or else the theme file at name.
+func LoadTheme(name string) (*Theme, error) {
+	if theme := BuiltinTheme(name); theme != nil {
+		return theme, nil
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("No theme %q: %v", name, err)
+	}
+	defer file.Close()
+
+	theme, err := ParseTheme(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return theme, nil
+}
+
+// Reads a theme, one "<key> <color>" pair per line, on top of the dark theme
+// or of the theme named by a "base <name>" line. Keys are the lower-cased
+// field names with dashes between words, eg. selection-text or deadline-soon,
+// priority.A etc. for priorities and tag.<name> for individual tags. Colors
+// are names or #rrggbb. Blank lines and lines starting with # are ignored.
+func ParseTheme(r io.Reader) (*Theme, error) {
+	theme := DarkTheme()
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"<key> <color>\"", line)
+		}
+		key, value := fields[0], fields[1]
+
+		if key == "base" {
+			base := BuiltinTheme(value)
+			if base == nil {
+				return nil, fmt.Errorf("line %d: unknown theme %q", line, value)
+			}
+			theme = base
+			continue
+		}
+
+		color := tcell.GetColor(strings.ToLower(value))
+		if color == tcell.ColorDefault && value != "default" {
+			return nil, fmt.Errorf("line %d: unknown color %q", line, value)
+		}
+
+		switch {
+		case strings.HasPrefix(key, "priority."):
+			priority := []rune(strings.TrimPrefix(key, "priority."))
+			if len(priority) != 1 {
+				return nil, fmt.Errorf("line %d: unknown priority %q", line, key)
+			}
+			theme.Priorities[priority[0]] = color
+		case strings.HasPrefix(key, "tag."):
+			theme.Tags[strings.TrimPrefix(key, "tag.")] = color
+		default:
+			field := theme.field(key)
+			if field == nil {
+				return nil, fmt.Errorf("line %d: unknown key %q", line, key)
+			}
+			*field = color
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	theme.Name = "custom"
+	return theme, nil
+}
+
+func (theme *Theme) field(key string) *tcell.Color {
+	switch key {
+	case "background":
+		return &theme.Background
+	case "contrast":
+		return &theme.Contrast
+	case "border":
+		return &theme.Border
+	case "title":
+		return &theme.Title
+	case "text":
+		return &theme.Text
+	case "secondary":
+		return &theme.Secondary
+	case "heading":
+		return &theme.Heading
+	case "body":
+		return &theme.Body
+	case "selection":
+		return &theme.Selection
+	case "selection-text":
+		return &theme.SelectionText
+	case "todo":
+		return &theme.Todo
+	case "done":
+		return &theme.Done
+	case "deadline-overdue":
+		return &theme.DeadlineOverdue
+	case "deadline-soon":
+		return &theme.DeadlineSoon
+	case "tag":
+		return &theme.Tag
+	case "log":
+		return &theme.Log
+	}
+	return nil
+}
+
+func (theme *Theme) TagColor(tag string) tcell.Color {
+	if color, ok := theme.Tags[tag]; ok {
+		return color
+	}
+	return theme.Tag
+}
+
+// Makes theme the current theme and sets tview.Styles to match.
+func (theme *Theme) Apply() {
+	CurrentTheme = theme
+
+	tview.Styles.PrimitiveBackgroundColor = theme.Background
+	tview.Styles.ContrastBackgroundColor = theme.Contrast
+	tview.Styles.MoreContrastBackgroundColor = theme.Contrast
+	tview.Styles.BorderColor = theme.Border
+	tview.Styles.TitleColor = theme.Title
+	tview.Styles.GraphicsColor = theme.Border
+	tview.Styles.PrimaryTextColor = theme.Text
+	tview.Styles.SecondaryTextColor = theme.Secondary
+	tview.Styles.TertiaryTextColor = theme.Body
+	tview.Styles.InverseTextColor = theme.Contrast
+	tview.Styles.ContrastSecondaryTextColor = theme.Secondary
+}
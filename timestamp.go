@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Headings have no scheduled time or deadline of their own, so they are read
+// from the SCHEDULED and DEADLINE properties, eg.
+//
+//	SCHEDULED=2020-09-14 Mon 10:00 +1w
+//	DEADLINE=<2020-09-30>
+//
+// The weekday is ignored. A repeater, "+" followed by a count and d, w, m or
+// y, makes the time recur every that many days, weeks, months or years.
+var timestampPattern = regexp.MustCompile(`^[<\[]?(\d{4}-\d{2}-\d{2})(?:\s+[[:alpha:]]+)?(?:\s+(\d{1,2}:\d{2}))?(?:\s+(?:\.|\+)?\+(\d+)([dwmy]))?[>\]]?$`)
+
+// Timestamp is a point in time as given in the SCHEDULED and DEADLINE
+// properties.
+type Timestamp struct {
+	Time time.Time
+	// Whether a time of day was given, or only the date.
+	HasTime bool
+	// The repeater, eg. 2 and 'w' for every other week. Every is 0 if the
+	// timestamp doesn't repeat.
+	Every int
+	Unit  byte
+}
+
+func ParseTimestamp(value string) (stamp Timestamp, err error) {
+	match := timestampPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return stamp, fmt.Errorf("Invalid timestamp %q, expected eg. \"2020-09-14 10:00 +1w\"", value)
+	}
+
+	layout, text := "2006-01-02", match[1]
+	if match[2] != "" {
+		layout, text = "2006-01-02 15:04", text+" "+match[2]
+		stamp.HasTime = true
+	}
+	if stamp.Time, err = time.ParseInLocation(layout, text, time.Local); err != nil {
+		return stamp, fmt.Errorf("Invalid timestamp %q: %v", value, err)
+	}
+	if match[3] != "" {
+		fmt.Sscan(match[3], &stamp.Every)
+		stamp.Unit = match[4][0]
+	}
+	return stamp, nil
+}
+
+// The recurrence rule for the timestamp's repeater, or "" if it has none.
+func (stamp Timestamp) RRule() string {
+	if stamp.Every == 0 {
+		return ""
+	}
+	freq := map[byte]string{'d': "DAILY", 'w': "WEEKLY", 'm': "MONTHLY", 'y': "YEARLY"}[stamp.Unit]
+	return fmt.Sprintf("FREQ=%v;INTERVAL=%d", freq, stamp.Every)
+}
@@ -0,0 +1,45 @@
+package main
+
+import "strings"
+
+// TODO keywords in the order they are cycled through. The last one marks an
+// item as done. Nodes without a keyword sort after all of these.
+var TodoKeywords = []string{"TODO", "DONE"}
+
+// Cycles through no keyword, then each of TodoKeywords in turn.
+func (node *AgendaNode) CycleTodo() {
+	rank := todoRank(node.Todo)
+	if rank+1 >= len(TodoKeywords) {
+		node.Todo = ""
+	} else {
+		node.Todo = TodoKeywords[rank+1]
+	}
+	node.Touch()
+}
+
+func (node *AgendaNode) IsDone() bool {
+	return node.Todo != "" && node.Todo == TodoKeywords[len(TodoKeywords)-1]
+}
+
+// Returns the index of keyword in TodoKeywords, or -1 for none.
+func todoRank(keyword string) int {
+	for i := range TodoKeywords {
+		if TodoKeywords[i] == keyword {
+			return i
+		}
+	}
+	return -1
+}
+
+// Splits a leading TODO keyword, eg. "TODO ", off text.
+func parseTodo(text string) (string, string) {
+	for _, keyword := range TodoKeywords {
+		if text == keyword {
+			return keyword, ""
+		}
+		if strings.HasPrefix(text, keyword+" ") {
+			return keyword, text[len(keyword)+1:]
+		}
+	}
+	return "", text
+}
@@ -1,16 +1,45 @@
 package main
 
 import (
+	"fmt"
 	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
+	"time"
 )
 
 type Tree struct {
 	*tview.Box
-	Root         *AgendaNode
-	Indent       int
-	Selected     *AgendaNode
-	selectedFunc func(*AgendaNode)
+	Root     *AgendaNode
+	Indent   int
+	Selected *AgendaNode
+	Clock    Clock
+	Theme    *Theme
+	// Where changes to the tree are recorded.
+	Journals Journals
+	// Headings that can't be moved or deleted and nothing can be put next to,
+	// ie. the files of a workspace.
+	Pinned map[*AgendaNode]bool
+	// Whether node is in a file open read-only, whose nodes can't be changed.
+	// May be nil.
+	ReadOnly func(node *AgendaNode) bool
+	// Index of the first line shown.
+	Offset int
+
+	// The selection as of the last draw, to scroll to it when it changes.
+	shownSelected *AgendaNode
+	// The node being dragged with the mouse, if any.
+	dragging *AgendaNode
+	selected func(node *AgendaNode)
+	// Lines as of the last draw, for mapping mouse positions back to nodes.
+	lines []treeLine
+}
+
+// One line of the rendered tree: the heading of a node or the text of a node
+// or continuation.
+type treeLine struct {
+	Node      *AgendaNode
+	Depth     int
+	IsHeading bool
 }
 
 func NewTree(root *AgendaNode) *Tree {
@@ -19,6 +48,8 @@ func NewTree(root *AgendaNode) *Tree {
 		Root:     root,
 		Indent:   5,
 		Selected: nil,
+		Theme:    CurrentTheme,
+		Pinned:   map[*AgendaNode]bool{},
 	}
 
 	if len(root.Children) > 0 {
@@ -28,82 +59,455 @@ func NewTree(root *AgendaNode) *Tree {
 	return result
 }
 
+// Sets a handler called with the node double-clicked.
+func (t *Tree) SetSelectedFunc(handler func(node *AgendaNode)) *Tree {
+	t.selected = handler
+	return t
+}
+
+func (t *Tree) layout() (lines []treeLine) {
+	t.Root.WalkUnfolded(func(node *AgendaNode, depth int) {
+		if !node.IsContinuation() {
+			lines = append(lines, treeLine{node, depth, true})
+			if node.Folded {
+				return
+			}
+		}
+		lines = append(lines, treeLine{node, depth, false})
+	})
+	return
+}
+
 func (t *Tree) Draw(screen tcell.Screen) {
 	t.Box.Draw(screen)
-	x, y, width, _ /*height*/ := t.GetInnerRect()
+	x, y, width, height := t.GetInnerRect()
+	now := time.Now()
 
-	t.Root.Walk(func(node *AgendaNode, indentLevel int) {
-		if !node.IsContinuation() {
-			tview.Print(screen, node.Title, x+(indentLevel*t.Indent), y, width, tview.AlignLeft, tview.Styles.PrimaryTextColor)
+	t.lines = t.layout()
 
-			if t.Selected == node {
-				textWidth := len(node.Title)
-				for bx := 0; bx < textWidth; bx++ {
-					m, c, style, _ := screen.GetContent(x+bx, y)
-					fg, _, _ := style.Decompose()
-					if fg == tview.Styles.PrimaryTextColor {
-						fg = tview.Styles.PrimitiveBackgroundColor
-					}
-					style = style.Background(tview.Styles.PrimaryTextColor).Foreground(fg)
-					screen.SetContent(x+(indentLevel*t.Indent)+bx, y, m, c, style)
+	if t.Selected != t.shownSelected {
+		t.shownSelected = t.Selected
+		for i, line := range t.lines {
+			if line.Node == t.Selected && line.IsHeading {
+				if i < t.Offset {
+					t.Offset = i
+				} else if i >= t.Offset+height {
+					t.Offset = i - height + 1
 				}
 			}
+		}
+	}
+	if t.Offset > len(t.lines)-height {
+		t.Offset = len(t.lines) - height
+	}
+	if t.Offset < 0 {
+		t.Offset = 0
+	}
+
+	for i := t.Offset; i < len(t.lines) && i < t.Offset+height; i++ {
+		node, lineX := t.lines[i].Node, x+t.lines[i].Depth*t.Indent
+
+		if !t.lines[i].IsHeading {
+			tview.Print(screen, node.Text, lineX, y, width, tview.AlignLeft, t.Theme.Body)
 			y++
+			continue
+		}
+
+		right := x + width
+		base := tcell.StyleDefault.Background(t.Theme.Background)
+		heading, priority := base.Foreground(t.Theme.Heading), base.Foreground(t.Theme.Priorities[node.Priority])
+		if t.Selected == node {
+			heading = base.Background(t.Theme.Selection).Foreground(t.Theme.SelectionText)
+			priority = heading
+		}
+
+		cx := lineX
+		if node.Todo != "" {
+			todo := base.Foreground(t.Theme.Todo)
+			if node.IsDone() {
+				todo = base.Foreground(t.Theme.Done)
+			}
+			if t.Selected == node {
+				todo = heading
+			}
+			cx = printCells(screen, cx, y, right, node.Todo, todo)
+			cx = printCells(screen, cx, y, right, " ", heading)
+		}
+		if node.Priority != 0 {
+			cx = printCells(screen, cx, y, right, fmt.Sprintf("[#%c]", node.Priority), priority)
+			cx = printCells(screen, cx, y, right, " ", heading)
+		}
+		cx = printCells(screen, cx, y, right, node.Title, heading)
+
+		if len(node.Tags) > 0 {
+			cx = printCells(screen, cx, y, right, " :", base.Foreground(t.Theme.Tag))
+			for _, tag := range node.Tags {
+				cx = printCells(screen, cx, y, right, tag, base.Foreground(t.Theme.TagColor(tag)))
+				cx = printCells(screen, cx, y, right, ":", base.Foreground(t.Theme.Tag))
+			}
+		}
+
+		if label, due := nodeDeadline(node); label != "" {
+			color := t.Theme.Secondary
+			switch {
+			case node.IsDone():
+			case !now.Before(due):
+				color = t.Theme.DeadlineOverdue
+			case due.Sub(now) < DeadlineWarning:
+				color = t.Theme.DeadlineSoon
+			}
+			cx = printCells(screen, cx, y, right, " "+label, base.Foreground(color))
 		}
 
-		tview.Print(screen, node.Text, x+(indentLevel*t.Indent), y, width, tview.AlignLeft, tview.Styles.TertiaryTextColor)
+		suffix := foldMarker(node)
+		own, total := node.ClockedTime(now), node.SubtreeClockedTime(now)
+		if total > 0 {
+			suffix = fmt.Sprintf("%v (%v/%v)", suffix, FormatDuration(own), FormatDuration(total))
+		}
+		printCells(screen, cx+1, y, right, suffix, base.Foreground(t.Theme.Secondary))
 		y++
-	})
+	}
 }
 
-func (t *Tree) InputHandler() func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
-	return t.WrapInputHandler(func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
-		isAltPressed := (event.Modifiers() & tcell.ModAlt) == tcell.ModAlt
-
-		switch event.Key() {
-		case tcell.KeyEnter:
-			t.selectedFunc(t.Selected)
-
-		case tcell.KeyRune:
-			switch event.Rune() {
-			case 'k':
-				if isAltPressed {
-					t.Selected.MakePrevSibling()
-				} else {
-					previous := t.Root.Prev(t.Selected)
-					if previous != nil {
-						t.Selected = previous
-					}
-				}
+// Prints text from x up to right in style and returns the x after it.
+func printCells(screen tcell.Screen, x, y, right int, text string, style tcell.Style) int {
+	for _, r := range text {
+		if x >= right {
+			break
+		}
+		screen.SetContent(x, y, r, nil, style)
+		x++
+	}
+	return x
+}
 
-			case 'j':
-				if isAltPressed {
-					t.Selected.MakeNextSibling()
-				} else {
-					next := t.Root.Next(t.Selected)
-					if next != nil {
-						t.Selected = next
-					}
-				}
+// Width of the heading of node as drawn, including priority, tags and
+// deadline.
+func headingWidth(node *AgendaNode) int {
+	width := len([]rune(node.Heading()))
+	if len(node.Tags) > 0 {
+		width += 2
+		for _, tag := range node.Tags {
+			width += len([]rune(tag)) + 1
+		}
+	}
+	if label, _ := nodeDeadline(node); label != "" {
+		width += 1 + len(label)
+	}
+	return width
+}
 
-			case 'h':
-				if isAltPressed {
-					t.Selected.MoveUpTree()
-				}
+// Drawn after a heading: "..." when it is folded, "[-]" when it has text or
+// children to fold and "" otherwise.
+func foldMarker(node *AgendaNode) string {
+	switch {
+	case node.Folded:
+		return "..."
+	case node.Text != "" || node.NextContinuation != nil || len(node.Children) > 0:
+		return "[-]"
+	}
+	return ""
+}
+
+// How long before its deadline a heading is shown in the DeadlineSoon color.
+var DeadlineWarning = 3 * 24 * time.Hour
 
-			case 'l':
-				if isAltPressed {
-					t.Selected.MoveDownTree()
+// The deadline of node as shown after its heading, eg. "due 2020-09-30", and
+// when it is due. Deadlines without a time of day are due by the end of it.
+func nodeDeadline(node *AgendaNode) (string, time.Time) {
+	value, ok := node.Properties.Get("DEADLINE")
+	if !ok {
+		return "", time.Time{}
+	}
+	stamp, err := ParseTimestamp(value)
+	if err != nil {
+		return "", time.Time{}
+	}
+	if !stamp.HasTime {
+		return "due " + stamp.Time.Format("2006-01-02"), stamp.Time.AddDate(0, 0, 1)
+	}
+	return "due " + stamp.Time.Format("2006-01-02 15:04"), stamp.Time
+}
+
+// Clicking a heading selects it, double-clicking opens it and clicking its
+// fold marker folds or unfolds it. The wheel scrolls. Dragging a heading
+// and dropping it on another line moves it below that line: as a child when
+// dropped right of the line's indentation, otherwise as a sibling.
+func (t *Tree) MouseHandler() func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+	return t.WrapMouseHandler(func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+		x, y := event.Position()
+		if !t.InRect(x, y) && t.dragging == nil {
+			return false, nil
+		}
+
+		rectX, rectY, _, _ := t.GetInnerRect()
+		column := x - rectX
+		var line *treeLine
+		if index := t.Offset + y - rectY; y >= rectY && index < len(t.lines) {
+			line = &t.lines[index]
+		}
+
+		switch action {
+		case tview.MouseLeftDown:
+			setFocus(t)
+			if line != nil && line.IsHeading {
+				t.Selected = line.Node
+				t.dragging = line.Node
+				return true, t
+			}
+			consumed = true
+
+		case tview.MouseMove:
+			if t.dragging != nil {
+				return true, t
+			}
+
+		case tview.MouseLeftUp:
+			dragged := t.dragging
+			t.dragging = nil
+			if dragged != nil && line != nil && !(line.Node == dragged && line.IsHeading) {
+				t.drop(dragged, *line, column)
+			}
+			consumed = true
+
+		case tview.MouseLeftClick:
+			if line != nil && line.IsHeading && foldMarker(line.Node) != "" {
+				markerX := line.Depth*t.Indent + headingWidth(line.Node) + 1
+				if column >= markerX && column < markerX+len(foldMarker(line.Node)) {
+					line.Node.Folded = !line.Node.Folded
 				}
 			}
+			consumed = true
+
+		case tview.MouseLeftDoubleClick:
+			if line != nil && line.IsHeading && t.selected != nil {
+				t.selected(line.Node)
+			}
+			consumed = true
+
+		case tview.MouseScrollUp:
+			t.Offset--
+			consumed = true
 
-		default:
+		case tview.MouseScrollDown:
+			t.Offset++
+			consumed = true
 		}
+
+		return
 	})
 }
 
-func (t *Tree) SetSelectedFunc(callback func(node *AgendaNode)) {
-	t.selectedFunc = callback
+// Whether node may be moved or deleted, warning if not.
+func (t *Tree) movable(node *AgendaNode) bool {
+	if t.Pinned[node] {
+		log.Warn("Can't move or delete %v", node.Title)
+		return false
+	}
+	return t.editable(node)
+}
+
+// Whether node may be changed, ie. isn't in a file open read-only.
+func (t *Tree) editable(node *AgendaNode) bool {
+	if node != nil && t.ReadOnly != nil && t.ReadOnly(node) {
+		log.Warn("Can't change %v, its file is open read-only", node.Head().Title)
+		return false
+	}
+	return true
+}
+
+// Moves node below line, as a child of the line's node when column is right of
+// its indentation and as the next sibling of the line's heading otherwise.
+func (t *Tree) drop(node *AgendaNode, line treeLine, column int) {
+	target := line.Node
+	for _, heading := range subtreeHeadings(node) {
+		if heading == target.Head() {
+			log.Warn("Can't move an item under itself")
+			return
+		}
+	}
+
+	if !t.movable(node) || !t.editable(target) {
+		return
+	}
+	if column < (line.Depth+1)*t.Indent && t.Pinned[target.Head()] {
+		log.Warn("Can't move an item out of its file")
+		return
+	}
+
+	from := PathOf(node)
+	defer func() { t.Journals.Moved(from, node) }()
+
+	node.Parent.RemoveChild(node)
+	if column >= (line.Depth+1)*t.Indent {
+		target.InsertChild(node, 0)
+		log.Info("Moved %v under %v", node.Title, target.Head().Title)
+		return
+	}
+
+	head := target.Head()
+	head.Parent.InsertChild(node, head.Parent.IndexChild(head)+1)
+	log.Info("Moved %v after %v", node.Title, head.Title)
+}
+
+func (t *Tree) SelectPrev() {
+	previous := t.Root.Prev(t.Selected)
+	if previous != nil {
+		t.Selected = previous
+	}
+}
+
+func (t *Tree) SelectNext() {
+	next := t.Root.Next(t.Selected)
+	if next != nil {
+		t.Selected = next
+	}
+}
+
+func (t *Tree) SelectFirst() {
+	if len(t.Root.Children) > 0 {
+		t.Selected = t.Root.Children[0]
+	}
+}
+
+func (t *Tree) SelectLast() {
+	t.Root.WalkUnfolded(func(node *AgendaNode, _ int) {
+		if !node.IsContinuation() {
+			t.Selected = node
+		}
+	})
+}
+
+// Removes the selected node along with its continuations and children, then
+// selects the next node, or the previous one if it was last.
+func (t *Tree) DeleteSelected() {
+	node := t.Selected
+	if node == nil || node.Parent == nil || !t.movable(node) {
+		return
+	}
+
+	inside := func(n *AgendaNode) bool {
+		for ; n != nil; n = n.Head().Parent {
+			if n.Head() == node {
+				return true
+			}
+		}
+		return false
+	}
+
+	replacement := t.Root.Next(node)
+	for replacement != nil && inside(replacement) {
+		replacement = t.Root.Next(replacement)
+	}
+	if replacement == nil {
+		replacement = t.Root.Prev(node)
+	}
+
+	if inside(t.Clock.Node) {
+		t.Clock.Out(time.Now())
+	}
+
+	t.Journals.Removed(node)
+	node.Parent.RemoveChild(node)
+	t.Selected = replacement
+}
+
+// Registers the actions operating on the selected node.
+func (t *Tree) RegisterActions(registry *ActionRegistry) {
+	// Wraps f so it is skipped while nothing is selected.
+	selected := func(f func(*AgendaNode)) func() {
+		return func() {
+			if t.Selected != nil {
+				f(t.Selected)
+			}
+		}
+	}
+
+	// Wraps a change of the selected node so it is skipped for read-only files.
+	edited := func(f func(*AgendaNode)) func() {
+		return selected(func(node *AgendaNode) {
+			if t.editable(node) {
+				f(node)
+			}
+		})
+	}
+
+	// Wraps a move of the selected node so it is journaled.
+	moved := func(f func(*AgendaNode)) func() {
+		return selected(func(node *AgendaNode) {
+			if !t.movable(node) {
+				return
+			}
+			from := PathOf(node)
+			f(node)
+			t.Journals.Moved(from, node)
+		})
+	}
+	sorted := func(key SortKey) func() {
+		return edited(func(node *AgendaNode) {
+			for segment := node; segment != nil; segment = segment.NextContinuation {
+				segment.SortChildren(key)
+			}
+			t.Journals.Sorted(node, key)
+		})
+	}
+
+	for _, action := range []*Action{
+		{Name: "select-prev", Description: "Select previous item in list.", Repeatable: true, Run: t.SelectPrev},
+		{Name: "select-next", Description: "Select next item in list.", Repeatable: true, Run: t.SelectNext},
+		{Name: "select-first", Description: "Select first item in list.", Run: t.SelectFirst},
+		{Name: "select-last", Description: "Select last item in list.", Run: t.SelectLast},
+		{Name: "outdent", Description: "Outdent the item one level.", Repeatable: true, Run: moved(func(node *AgendaNode) {
+			if node.Parent != nil && t.Pinned[node.Parent.Head()] {
+				log.Warn("Can't move an item out of its file")
+				return
+			}
+			node.MoveUpTree()
+		})},
+		{Name: "indent", Description: "Indent the item one level.", Repeatable: true, Run: moved((*AgendaNode).MoveDownTree)},
+		{Name: "move-up", Description: "Move an item up in the list. (Preserves nesting level.)", Repeatable: true, Run: moved((*AgendaNode).MakePrevSibling)},
+		{Name: "move-down", Description: "Move an item down in the list. (Preserves nesting level.)", Repeatable: true, Run: moved((*AgendaNode).MakeNextSibling)},
+		{Name: "delete", Description: "Delete the selected item and everything below it.", Repeatable: true, Run: t.DeleteSelected},
+		{Name: "fold", Description: "Fold the selected item.", Run: selected(func(node *AgendaNode) { node.Folded = true })},
+		{Name: "unfold", Description: "Unfold the selected item.", Run: selected(func(node *AgendaNode) { node.Folded = false })},
+		{Name: "toggle-fold", Description: "Toggle folding of the selected item.", Run: selected(func(node *AgendaNode) { node.Folded = !node.Folded })},
+		{Name: "clock-in", Description: "Clock in to the selected item.", Run: func() {
+			if !t.editable(t.Selected) || !t.editable(t.Clock.Node) {
+				return
+			}
+			if err := t.Clock.In(t.Selected, time.Now()); err != nil {
+				log.Warn("%v", err)
+				return
+			}
+			t.Journals.EditedHeader(t.Clock.Node)
+		}},
+		{Name: "clock-out", Description: "Clock out of the running clock.", Run: func() {
+			node := t.Clock.Node
+			if !t.editable(node) {
+				return
+			}
+			if err := t.Clock.Out(time.Now()); err != nil {
+				log.Warn("%v", err)
+				return
+			}
+			t.Journals.EditedHeader(node)
+		}},
+		{Name: "cycle-priority", Description: "Cycle the priority of the selected item.", Run: edited(func(node *AgendaNode) {
+			node.CyclePriority()
+			t.Journals.EditedHeader(node)
+		})},
+		{Name: "cycle-todo", Description: "Cycle the TODO keyword of the selected item.", Run: edited(func(node *AgendaNode) {
+			node.CycleTodo()
+			t.Journals.EditedHeader(node)
+		})},
+		{Name: "sort-priority", Description: "Sort the children of the selected item by priority.", Run: sorted(SortByPriority)},
+		{Name: "sort-title", Description: "Sort the children of the selected item by title.", Run: sorted(SortByTitle)},
+		{Name: "sort-todo", Description: "Sort the children of the selected item by TODO keyword.", Run: sorted(SortByTodo)},
+		{Name: "sort-deadline", Description: "Sort the children of the selected item by deadline.", Run: sorted(SortByDeadline)},
+	} {
+		action.Scope = ScopeMain
+		registry.Register(action)
+	}
 }
 
 // func main() {
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rivo/tview"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Remembers data as the contents of the file on disk, so only changes by
+// others are noticed by Check. info is the file's as stat'ed before data was
+// read, so any later change is noticed, or nil if data was already seen by
+// Check.
+func (file *AgendaFile) setDisk(data []byte, info os.FileInfo) {
+	file.disk = string(data)
+	if info != nil {
+		file.seen(info)
+	}
+}
+
+// Marks the version of the file described by info as checked.
+func (file *AgendaFile) seen(info os.FileInfo) {
+	file.diskModTime, file.diskSize = info.ModTime(), info.Size()
+}
+
+// Polls the open files for changes made outside the app every WatchInterval.
+func (a *AgendaApp) watch() {
+	if a.WatchInterval <= 0 {
+		return
+	}
+	for range time.Tick(a.WatchInterval) {
+		a.QueueUpdateDraw(func() {
+			if err := a.CheckFiles(); err != nil {
+				log.Warn("Couldn't check for changes: %v", err)
+			}
+		})
+	}
+}
+
+// Checks whether the file was changed outside the app. If it was, it is
+// reloaded when there are no unsaved changes, and otherwise the user is asked
+// what to do. Changes while the user is being asked are resolved along with
+// the first.
+func (file *AgendaFile) Check() error {
+	info, err := os.Stat(file.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(file.diskModTime) && info.Size() == file.diskSize {
+		return nil
+	}
+	asking, other := false, false
+	for _, modal := range file.app.Modals.Modals {
+		if modal.Name == "conflict" {
+			asking, other = asking || modal.State == file, other || modal.State != file
+		}
+	}
+	if other && !asking {
+		// Another file's conflict is being resolved. This one is checked again
+		// afterwards.
+		return nil
+	}
+	file.seen(info)
+
+	data, err := ioutil.ReadFile(file.Path)
+	if err != nil {
+		return err
+	}
+	if string(data) == file.disk && !asking {
+		return nil
+	}
+	theirs, err := ParseAgenda(bytes.NewReader(data))
+	if err != nil {
+		// Possibly caught halfway through being written. It's checked again
+		// when it changes next.
+		return fmt.Errorf("%v: %v", file.Path, err)
+	}
+
+	file.theirs = data
+	if asking {
+		log.Info("%v changed on disk again", file.Path)
+		return nil
+	}
+	if !file.IsDirty() {
+		file.reload(theirs, data)
+		log.Info("Reloaded %v, which changed on disk", file.Path)
+		return nil
+	}
+	file.offerMerge()
+	return nil
+}
+
+// Replaces the contents of the file with root, read from data on disk, keeping
+// the selection in place if possible.
+func (file *AgendaFile) reload(root *AgendaNode, data []byte) {
+	tree := file.app.Tree
+	var path NodePath
+	if tree.Selected != nil && file.Root.Contains(tree.Selected) {
+		path = PathOf(tree.Selected.Head())
+	}
+	file.load(root)
+	if selected, err := file.app.Root.Resolve(path); err == nil && path != nil && file.Root.Contains(selected) {
+		tree.Selected = selected
+	}
+
+	file.saved = string(FormatAgenda(file.Root))
+	file.autosaved = ""
+	file.setDisk(data, nil)
+	if err := os.Remove(AutosavePath(file.Path)); err != nil && !os.IsNotExist(err) {
+		log.Warn("Couldn't remove autosave: %v", err)
+	}
+	if err := file.journal.Reset(data); err != nil {
+		log.Warn("Couldn't restart journal: %v", err)
+	}
+}
+
+// Asks whether to keep the unsaved changes, take the changed file or merge
+// both, when the file changed on disk while there are unsaved changes.
+func (file *AgendaFile) offerMerge() {
+	dialog := tview.NewModal()
+	dialog.SetText(fmt.Sprintf("%v changed on disk, but there are unsaved changes.", file.Path))
+	dialog.AddButtons([]string{"Keep mine", "Take theirs", "Merge"})
+	dialog.SetDoneFunc(func(_ int, label string) {
+		file.app.Modals.Pop()
+		switch label {
+		case "Keep mine":
+			file.KeepMine()
+		case "Take theirs":
+			file.TakeTheirs()
+		case "Merge":
+			file.Merge()
+		}
+	})
+
+	file.app.Modals.Push(&Modal{Widget: &Widget{Name: "conflict", Primitive: dialog}, IsPage: true, TakesText: true, State: file})
+}
+
+// Resolves a change on disk by keeping the file as is, to be saved over it.
+func (file *AgendaFile) KeepMine() {
+	theirs, err := ParseAgenda(bytes.NewReader(file.theirs))
+	if err != nil {
+		log.Error("%v: %v", file.Path, err)
+		return
+	}
+	file.saved = string(FormatAgenda(theirs))
+	file.setDisk(file.theirs, nil)
+	file.rewriteAutosave()
+}
+
+// Resolves a change on disk by dropping unsaved changes and reloading.
+func (file *AgendaFile) TakeTheirs() {
+	theirs, err := ParseAgenda(bytes.NewReader(file.theirs))
+	if err != nil {
+		log.Error("%v: %v", file.Path, err)
+		return
+	}
+	file.reload(theirs, file.theirs)
+	log.Info("Reloaded %v", file.Path)
+}
+
+// Resolves a change on disk by merging it with the unsaved changes, relative
+// to the file as it was last loaded or saved. The result is left unsaved.
+func (file *AgendaFile) Merge() {
+	base, err := ParseAgenda(strings.NewReader(file.disk))
+	if err != nil {
+		log.Error("%v: %v", file.Path, err)
+		return
+	}
+	theirs, err := ParseAgenda(bytes.NewReader(file.theirs))
+	if err != nil {
+		log.Error("%v: %v", file.Path, err)
+		return
+	}
+	saved := string(FormatAgenda(theirs))
+
+	mine := NewNode("", "")
+	mine.ReplaceContents(file.Root)
+	merged, conflicts := MergeAgendas(base, mine, theirs)
+	file.load(merged)
+
+	file.saved = saved
+	file.setDisk(file.theirs, nil)
+	file.rewriteAutosave()
+	for _, title := range conflicts {
+		log.Warn("Conflicting changes to %v", title)
+	}
+	log.Info("Merged changes to %v with %d conflicts", file.Path, len(conflicts))
+}
+
+// Autosaves even if nothing changed since the last autosave, so it is newer
+// than the file on disk and offered for recovery after a crash.
+func (file *AgendaFile) rewriteAutosave() {
+	file.autosaved = ""
+	if err := file.Autosave(); err != nil {
+		log.Error("Autosave failed: %v", err)
+	}
+}
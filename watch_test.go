@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckFileReloadsOrMerges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agenda.txt")
+	write := func(text string) {
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("* a\n* b\n")
+
+	app := NewAgendaApp(NewNode("", ""))
+	if err := app.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+
+	write("* a\n* b\n* theirs\n")
+	if err := app.CheckFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(FormatAgenda(app.Root)); actual != "* a\n* b\n* theirs\n" {
+		t.Errorf("Expected to reload without local changes, got %q", actual)
+	}
+
+	app.Root.Children[0].Title = "mine"
+	app.Root.Children[0].Touch()
+	write("* a\n* b\n* theirs\n* more\n")
+	if err := app.CheckFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if app.Modals.Top().Name != "conflict" {
+		t.Fatalf("Expected a conflict dialog, got %v", app.Modals.Top().Name)
+	}
+	app.Modals.Pop()
+	app.Files[0].Merge()
+	if actual := string(FormatAgenda(app.Root)); actual != "* mine\n* b\n* theirs\n* more\n" {
+		t.Errorf("Expected merged agenda, got %q", actual)
+	}
+	if !app.IsDirty() || !HasNewerAutosave(path) {
+		t.Error("Expected the merge to be unsaved and autosaved")
+	}
+	if err := app.CheckFiles(); err != nil || app.Modals.Top().Name == "conflict" {
+		t.Errorf("Expected no further conflict, got %v", err)
+	}
+}
+
+func TestCheckFileKeepsChangesWhileAsking(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agenda.txt")
+	write := func(text string) {
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("* a\n")
+
+	app := NewAgendaApp(NewNode("", ""))
+	if err := app.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+
+	app.Root.Children[0].Title = "mine"
+	app.Root.Children[0].Touch()
+	write("* a\n* theirs1\n")
+	if err := app.CheckFiles(); err != nil {
+		t.Fatal(err)
+	}
+	write("* a\n* theirs1\n* theirs2\n")
+	if err := app.CheckFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(app.Modals.Modals); app.Modals.Top().Name != "conflict" || n != 2 {
+		t.Fatalf("Expected one conflict dialog, got %v of %d modals", app.Modals.Top().Name, n)
+	}
+
+	app.Modals.Pop()
+	app.Files[0].Merge()
+	if err := app.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := ioutil.ReadFile(path); !strings.Contains(string(saved), "* mine\n") || !strings.Contains(string(saved), "* theirs1\n* theirs2\n") {
+		t.Errorf("Expected both changes on disk to be merged, got %q", saved)
+	}
+	if err := app.CheckFiles(); err != nil || app.Modals.Top().Name == "conflict" {
+		t.Errorf("Expected no further conflict, got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// AgendaFile is a file open in the app. Its headings are the children of Root,
+// which is the root of the tree when only one file is open. With several open
+// they form a workspace: each file is a heading below the root, titled after
+// the file, and saved back to it.
+type AgendaFile struct {
+	Path string
+	Root *AgendaNode
+	// Set when another instance holds the lock on the file. Nothing is written
+	// then, neither the file nor its autosave and journal.
+	ReadOnly bool
+
+	app *AgendaApp
+	// The file as last saved and autosaved.
+	saved             string
+	autosaved         string
+	backedUp          bool
+	autosaveScheduled bool
+	// The file as last read or written, and its version changed outside the
+	// app.
+	disk        string
+	diskModTime time.Time
+	diskSize    int64
+	theirs      []byte
+	lock        *Lock
+	journal     *Journal
+	// FormatAgenda of Root as of its Revision formattedRevision.
+	formatted         string
+	formattedRevision int
+	formattedValid    bool
+}
+
+// Opens the agenda files at paths, replacing those open before. See
+// AgendaFile.Open for how each is opened.
+func (a *AgendaApp) Open(paths ...string) error {
+	a.Close()
+	a.Root.ReplaceContents(NewNode("", ""))
+
+	for _, path := range paths {
+		file := &AgendaFile{Path: path, Root: a.Root, app: a}
+		if len(paths) > 1 {
+			file.Root = NewNode(filepath.Base(path), "")
+			a.Root.AddChild(file.Root)
+			a.Tree.Pinned[file.Root] = true
+		}
+		a.Files = append(a.Files, file)
+	}
+	for _, file := range a.Files {
+		if err := file.Open(); err != nil {
+			a.Close()
+			return err
+		}
+	}
+
+	a.Tree.Selected = nil
+	a.Tree.SelectFirst()
+	return nil
+}
+
+// Releases all open files.
+func (a *AgendaApp) Close() {
+	for _, file := range a.Files {
+		file.Close()
+	}
+	a.Files = nil
+	a.Tree.Journals = nil
+	a.Tree.Pinned = map[*AgendaNode]bool{}
+}
+
+// The open file node belongs to, if any.
+func (a *AgendaApp) FileOf(node *AgendaNode) *AgendaFile {
+	if node == nil {
+		return nil
+	}
+	for _, file := range a.Files {
+		if file.Root.Contains(node) {
+			return file
+		}
+	}
+	return nil
+}
+
+// Saves every open file that isn't read-only.
+func (a *AgendaApp) Save() error {
+	if len(a.Files) == 0 {
+		return fmt.Errorf("No file to save to")
+	}
+
+	var first error
+	for _, file := range a.Files {
+		if file.ReadOnly && !file.IsDirty() {
+			continue
+		}
+		if err := file.Save(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Saves the agenda to path from now on. Only works with at most one file
+// open.
+func (a *AgendaApp) SaveAs(path string) error {
+	switch len(a.Files) {
+	case 0:
+		a.Files = []*AgendaFile{{Root: a.Root, app: a}}
+	case 1:
+	default:
+		return fmt.Errorf("Can only save to another file with one file open")
+	}
+	return a.Files[0].SaveAs(path)
+}
+
+// Autosaves every open file with unsaved changes.
+func (a *AgendaApp) Autosave() error {
+	var first error
+	for _, file := range a.Files {
+		if err := file.Autosave(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Whether any open file has unsaved changes.
+func (a *AgendaApp) IsDirty() bool {
+	for _, file := range a.Files {
+		if file.IsDirty() {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks every open file for changes made outside the app. See
+// AgendaFile.Check.
+func (a *AgendaApp) CheckFiles() error {
+	var first error
+	for _, file := range a.Files {
+		if err := file.Check(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (a *AgendaApp) scheduleAutosave() {
+	for _, file := range a.Files {
+		file.scheduleAutosave()
+	}
+}
+
+// Keeps the selection and running clock valid after files were loaded.
+func (a *AgendaApp) loaded() {
+	if a.Tree.Selected == nil || !a.Root.Contains(a.Tree.Selected) {
+		a.Tree.Selected = nil
+		a.Tree.SelectFirst()
+	}
+	a.Tree.Clock.Node = a.Root.RunningClock()
+}
+
+// Builds the tree of the agenda files at paths the way Open does, without
+// opening them for editing.
+func LoadAgendaFiles(paths ...string) (*AgendaNode, error) {
+	if len(paths) == 1 {
+		return LoadAgendaFile(paths[0])
+	}
+
+	root := NewNode("", "")
+	for _, path := range paths {
+		file, err := LoadAgendaFile(path)
+		if err != nil {
+			return nil, err
+		}
+		node := NewNode(filepath.Base(path), "")
+		node.ReplaceContents(file)
+		root.AddChild(node)
+	}
+	return root, nil
+}
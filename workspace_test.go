@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkspaceSavesToOriginatingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	work, home := filepath.Join(dir, "work.txt"), filepath.Join(dir, "home.txt")
+	if err := ioutil.WriteFile(work, []byte("* report\n  * draft\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(home, []byte("* garden\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewAgendaApp(NewNode("", ""))
+	if err := app.Open(work, home); err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Root.Children) != 2 || app.Root.Children[0].Title != "work.txt" || app.Root.Children[1].Title != "home.txt" {
+		t.Fatalf("Expected a heading per file, got\n%s", FormatAgenda(app.Root))
+	}
+
+	// Refile across files, then crash.
+	draft := headingTitled(app.Root, "draft")
+	app.Tree.Selected = draft
+	if err := app.CommandLine.Execute("move under garden"); err != nil {
+		t.Fatal(err)
+	}
+	if app.FileOf(draft).Path != home {
+		t.Errorf("Expected draft to belong to %v now", home)
+	}
+	app.Close()
+
+	reopened := NewAgendaApp(NewNode("", ""))
+	if err := reopened.Open(work, home); err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	expected := "* work.txt\n  * report\n* home.txt\n  * garden\n    * draft\n"
+	if actual := string(FormatAgenda(reopened.Root)); actual != expected {
+		t.Errorf("Expected replayed workspace\n%v\ngot\n%v", expected, actual)
+	}
+
+	reopened.Tree.Selected = reopened.Root.Children[0]
+	reopened.Actions.Find("delete").Run()
+	if len(reopened.Root.Children) != 2 {
+		t.Error("Expected files to be kept from deletion")
+	}
+
+	if err := reopened.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := ioutil.ReadFile(work); string(saved) != "* report\n" {
+		t.Errorf("Expected %v to lose draft, got %q", work, saved)
+	}
+	if saved, _ := ioutil.ReadFile(home); string(saved) != "* garden\n  * draft\n" {
+		t.Errorf("Expected %v to gain draft, got %q", home, saved)
+	}
+}
+
+func TestIsDirtyFollowsRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	ioutil.WriteFile(a, []byte("* a\n  * a1\n"), 0644)
+	ioutil.WriteFile(b, []byte("* b\n"), 0644)
+
+	app := NewAgendaApp(NewNode("", ""))
+	if err := app.Open(a, b); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+	fileA, fileB := app.Files[0], app.Files[1]
+	if fileA.IsDirty() || fileB.IsDirty() {
+		t.Fatal("Expected no unsaved changes after opening")
+	}
+
+	revisionA, revisionB := fileA.Root.Revision(), fileB.Root.Revision()
+	a1 := headingTitled(app.Root, "a1")
+	a1.Title = "changed"
+	a1.Touch()
+	if fileA.Root.Revision() == revisionA || fileB.Root.Revision() != revisionB {
+		t.Error("Expected Touch to change the revision of a's file only")
+	}
+	if !fileA.IsDirty() || fileB.IsDirty() {
+		t.Error("Expected only a to have unsaved changes")
+	}
+
+	a1.Title = "a1"
+	a1.Touch()
+	if fileA.IsDirty() {
+		t.Error("Expected no unsaved changes after changing back")
+	}
+}